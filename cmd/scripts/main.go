@@ -1,16 +1,44 @@
 package main
 
 import (
+	"log"
+	"net/http"
+
 	"github.com/spf13/cobra"
+	"github.com/zeu5/visualizations/scripts/aishe"
 	datagovin "github.com/zeu5/visualizations/scripts/data.gov.in"
+	"github.com/zeu5/visualizations/util/metrics"
+)
+
+var (
+	metricsAddr string
 )
 
+// serveMetrics exposes the prometheus metrics of the running script
+func serveMetrics(cmd *cobra.Command, args []string) {
+	if metricsAddr == "" {
+		return
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			log.Printf("Metrics server stopped: %s\n", err)
+		}
+	}()
+}
+
 func ScriptsRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "scripts",
-		Short: "run specified script",
+		Use:              "scripts",
+		Short:            "run specified script",
+		PersistentPreRun: serveMetrics,
 	}
+	cmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "Address to serve prometheus metrics at, disabled when empty")
 	cmd.AddCommand(datagovin.CrimeCmd())
+	cmd.AddCommand(datagovin.DataGovInCmd())
+	cmd.AddCommand(datagovin.SyncCmd())
+	cmd.AddCommand(aishe.AisheCmd())
 	return cmd
 }
 
@@ -0,0 +1,136 @@
+package aishe
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Institution counts the higher education institutions of a state, or of a
+// district of it, in a survey of the All India Survey on Higher Education
+type Institution struct {
+	mgm.DefaultModel `json:"-"`
+	// Year is the first year of the academic year surveyed, 2019 for 2019-20
+	Year      int    `json:"year" bson:"year"`
+	State     string `json:"state" bson:"state"`
+	StateCode string `json:"state_code" bson:"state_code"`
+	// District is empty for the totals of the state
+	District     string `json:"district,omitempty" bson:"district,omitempty"`
+	Universities int    `json:"universities" bson:"universities"`
+	Colleges     int    `json:"colleges" bson:"colleges"`
+	Standalone   int    `json:"standalone" bson:"standalone"`
+}
+
+func (i *Institution) CollectionName() string {
+	return "aishe_institutions"
+}
+
+// Enrolment counts the students of a level of programmes in a state, or in a
+// district of it, in a survey year
+type Enrolment struct {
+	mgm.DefaultModel `json:"-"`
+	Year             int    `json:"year" bson:"year"`
+	State            string `json:"state" bson:"state"`
+	StateCode        string `json:"state_code" bson:"state_code"`
+	District         string `json:"district,omitempty" bson:"district,omitempty"`
+	// Level is the level of the programmes, such as Under Graduate or Ph.D.
+	Level  string `json:"level" bson:"level"`
+	Male   int    `json:"male" bson:"male"`
+	Female int    `json:"female" bson:"female"`
+	Total  int    `json:"total" bson:"total"`
+}
+
+func (e *Enrolment) CollectionName() string {
+	return "aishe_enrolments"
+}
+
+// Query selects institution and enrolment rows. Zero fields select every
+// row, except Districts which selects district rows instead of state totals
+type Query struct {
+	Year int
+	// State is the name or the code of the state
+	State     string
+	Districts bool
+	// Level only applies to enrolments
+	Level string
+}
+
+func (q Query) filter() bson.M {
+	filter := bson.M{}
+	if q.Year != 0 {
+		filter["year"] = q.Year
+	}
+	if q.State != "" {
+		filter["$or"] = []bson.M{{"state": q.State}, {"state_code": q.State}}
+	}
+	filter["district"] = bson.M{"$exists": q.Districts}
+	return filter
+}
+
+func sortRows() *options.FindOptions {
+	return options.Find().SetSort(bson.D{
+		{Key: "year", Value: 1},
+		{Key: "state", Value: 1},
+		{Key: "district", Value: 1},
+	})
+}
+
+// Institutions returns the institution counts selected by q
+func Institutions(q Query) ([]*Institution, error) {
+	coll := mgm.Coll(&Institution{})
+	ctx := mgm.Ctx()
+
+	cur, err := coll.Find(ctx, q.filter(), sortRows())
+	if err != nil {
+		return []*Institution{}, fmt.Errorf("error fetching from db: %s", err)
+	}
+	institutions := make([]*Institution, 0)
+	err = cur.All(ctx, &institutions)
+	if err != nil {
+		return []*Institution{}, fmt.Errorf("error fetching from db: %s", err)
+	}
+	return institutions, nil
+}
+
+// Enrolments returns the enrolment counts selected by q
+func Enrolments(q Query) ([]*Enrolment, error) {
+	coll := mgm.Coll(&Enrolment{})
+	ctx := mgm.Ctx()
+
+	filter := q.filter()
+	if q.Level != "" {
+		filter["level"] = q.Level
+	}
+	cur, err := coll.Find(ctx, filter, sortRows())
+	if err != nil {
+		return []*Enrolment{}, fmt.Errorf("error fetching from db: %s", err)
+	}
+	enrolments := make([]*Enrolment, 0)
+	err = cur.All(ctx, &enrolments)
+	if err != nil {
+		return []*Enrolment{}, fmt.Errorf("error fetching from db: %s", err)
+	}
+	return enrolments, nil
+}
+
+// Years returns the survey years with institution counts
+func Years() ([]int, error) {
+	values, err := mgm.Coll(&Institution{}).Distinct(mgm.Ctx(), "year", bson.M{})
+	if err != nil {
+		return []int{}, fmt.Errorf("error fetching from db: %s", err)
+	}
+	years := make([]int, 0, len(values))
+	for _, v := range values {
+		switch y := v.(type) {
+		case int32:
+			years = append(years, int(y))
+		case int64:
+			years = append(years, int(y))
+		}
+	}
+	sort.Ints(years)
+	return years, nil
+}
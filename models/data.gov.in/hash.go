@@ -0,0 +1,53 @@
+package datagovin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// canonicalValue normalises a cell so that the same value hashes alike
+// whether it was exported as a number or a string, padded or not
+func canonicalValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		s = strings.TrimSpace(value)
+	default:
+		s = strings.TrimSpace(fmt.Sprint(value))
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return s
+}
+
+// Hash returns the sha256 of the normalised fields and entries of the data,
+// tables with the same contents have the same hash
+func (d Data) Hash() (string, error) {
+	fields := make([][2]string, len(d.Fields))
+	for i, f := range d.Fields {
+		fields[i] = [2]string{strings.TrimSpace(f["id"]), strings.TrimSpace(f["label"])}
+	}
+	entries := make([][]string, len(d.Entries))
+	for i, entry := range d.Entries {
+		entries[i] = make([]string, len(entry))
+		for j, v := range entry {
+			entries[i][j] = canonicalValue(v)
+		}
+	}
+	encoded, err := json.Marshal(map[string]interface{}{
+		"fields":  fields,
+		"entries": entries,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not encode data: %s", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,88 @@
+package datagovin
+
+import "testing"
+
+func TestDataHash(t *testing.T) {
+	base := Data{
+		Fields:  []map[string]string{{"id": "state", "label": "State"}, {"id": "cases", "label": "Cases"}},
+		Entries: [][]interface{}{{"Kerala", float64(12)}, {"Goa", float64(3.5)}},
+	}
+	tests := []struct {
+		name string
+		data Data
+		same bool
+	}{
+		{"identical", Data{
+			Fields:  []map[string]string{{"id": "state", "label": "State"}, {"id": "cases", "label": "Cases"}},
+			Entries: [][]interface{}{{"Kerala", float64(12)}, {"Goa", float64(3.5)}},
+		}, true},
+		{"numbers as strings", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Kerala", "12"}, {"Goa", "3.50"}},
+		}, true},
+		{"integers", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Kerala", 12}, {"Goa", "3.5"}},
+		}, true},
+		{"padded", Data{
+			Fields:  []map[string]string{{"id": " state", "label": "State "}, {"id": "cases", "label": "Cases"}},
+			Entries: [][]interface{}{{" Kerala ", " 12"}, {"Goa", float64(3.5)}},
+		}, true},
+		{"other field keys", Data{
+			Fields:  []map[string]string{{"id": "state", "label": "State", "type": "string"}, {"id": "cases", "label": "Cases", "type": "double"}},
+			Entries: base.Entries,
+		}, true},
+		{"changed value", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Kerala", float64(13)}, {"Goa", float64(3.5)}},
+		}, false},
+		{"changed label", Data{
+			Fields:  []map[string]string{{"id": "state", "label": "State/UT"}, {"id": "cases", "label": "Cases"}},
+			Entries: base.Entries,
+		}, false},
+		{"reordered rows", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Goa", float64(3.5)}, {"Kerala", float64(12)}},
+		}, false},
+		{"missing row", Data{
+			Fields:  base.Fields,
+			Entries: base.Entries[:1],
+		}, false},
+		{"cells moved between rows", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Kerala", float64(12), "Goa"}, {float64(3.5)}},
+		}, false},
+		{"empty cell", Data{
+			Fields:  base.Fields,
+			Entries: [][]interface{}{{"Kerala", nil}, {"Goa", float64(3.5)}},
+		}, false},
+	}
+	want, err := base.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.data.Hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == want) != tt.same {
+				t.Fatalf("got hash %s, base hash %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDataHashEmpty(t *testing.T) {
+	empty, err := Data{}.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noEntries, _ := (Data{Fields: []map[string]string{}, Entries: [][]interface{}{}}).Hash(); noEntries != empty {
+		t.Fatal("nil and empty data hash differently")
+	}
+	if nilCell, _ := (Data{Entries: [][]interface{}{{nil}}}).Hash(); nilCell == empty {
+		t.Fatal("a row of empty cells hashes like no rows")
+	}
+}
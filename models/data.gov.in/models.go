@@ -14,6 +14,11 @@ type Catalog struct {
 	CatID            uint64                 `json:"cat_id" bson:"cat_id"`
 	Departments      []string               `json:"department" bson:"department"`
 	Other            map[string]interface{} `json:"other" bson:"other"`
+	// Removed is set once the catalog is no longer listed by data.gov.in
+	Removed bool `json:"removed,omitempty" bson:"removed,omitempty"`
+	// Source is the portal the catalog was fetched from, empty for catalogs
+	// fetched from data.gov.in before other portals were supported
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
 }
 
 func (c *Catalog) CollectionName() string {
@@ -29,6 +34,15 @@ type Dataset struct {
 	LastModified     time.Time              `json:"last_modified" bson:"last_modified"`
 	Other            map[string]interface{} `json:"other" bson:"other"`
 	Data             Data                   `json:"data" bson:"data"`
+	// Removed is set once the dataset is no longer listed by data.gov.in
+	Removed bool `json:"removed,omitempty" bson:"removed,omitempty"`
+	// Hash is the content hash of Data, see Data.Hash
+	Hash string `json:"hash,omitempty" bson:"hash,omitempty"`
+	// DuplicateOf is the dataset with the same contents this one was merged
+	// into, its Data is then dropped
+	DuplicateOf uint64 `json:"duplicate_of,omitempty" bson:"duplicate_of,omitempty"`
+	// Source is the portal the dataset was fetched from, see Catalog.Source
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
 }
 
 func (d *Dataset) CollectionName() string {
@@ -39,3 +53,78 @@ type Data struct {
 	Fields  []map[string]string `json:"fields" bson:"fields"`
 	Entries [][]interface{}     `json:"entries" bson:"entries"`
 }
+
+// JobState is the progress of a catalog or dataset in the fetch journal
+type JobState string
+
+const (
+	JobPending  JobState = "pending"
+	JobFetching JobState = "fetching"
+	JobDone     JobState = "done"
+	JobFailed   JobState = "failed"
+)
+
+// ItemKind tells whether a journal entry or change is about a catalog or a
+// dataset
+type ItemKind string
+
+const (
+	ItemCatalog ItemKind = "catalog"
+	ItemDataset ItemKind = "dataset"
+)
+
+// Job is an entry of the fetch journal. It keeps the catalog or dataset as
+// fetched from data.gov.in so that an interrupted run can be resumed
+type Job struct {
+	mgm.DefaultModel `json:"-"`
+	Kind             ItemKind `json:"kind" bson:"kind"`
+	ItemID           uint64   `json:"item_id" bson:"item_id"`
+	State            JobState `json:"state" bson:"state"`
+	Reason           string   `json:"reason,omitempty" bson:"reason,omitempty"`
+	Attempts         int      `json:"attempts" bson:"attempts"`
+	Catalog          *Catalog `json:"catalog,omitempty" bson:"catalog,omitempty"`
+	Dataset          *Dataset `json:"dataset,omitempty" bson:"dataset,omitempty"`
+}
+
+func (j *Job) CollectionName() string {
+	return "data_gov_in_jobs"
+}
+
+// ChangeKind is how a catalog or dataset changed upstream
+type ChangeKind string
+
+const (
+	ChangeDeleted  ChangeKind = "deleted"
+	ChangeRetitled ChangeKind = "retitled"
+)
+
+// Change records a catalog or dataset that was removed from or retitled on
+// data.gov.in after it was fetched
+type Change struct {
+	mgm.DefaultModel `json:"-"`
+	Topic            string     `json:"topic" bson:"topic"`
+	Kind             ItemKind   `json:"kind" bson:"kind"`
+	ItemID           uint64     `json:"item_id" bson:"item_id"`
+	CatID            uint64     `json:"cat_id" bson:"cat_id"`
+	Change           ChangeKind `json:"change" bson:"change"`
+	OldTitle         string     `json:"old_title" bson:"old_title"`
+	NewTitle         string     `json:"new_title,omitempty" bson:"new_title,omitempty"`
+	DetectedAt       time.Time  `json:"detected_at" bson:"detected_at"`
+}
+
+func (c *Change) CollectionName() string {
+	return "data_gov_in_changes"
+}
+
+// SyncState is the high-water mark of a topic, the latest change time of the
+// catalogs seen by its last complete sync
+type SyncState struct {
+	mgm.DefaultModel `json:"-"`
+	Topic            string    `json:"topic" bson:"topic"`
+	HighWater        time.Time `json:"high_water" bson:"high_water"`
+	LastSync         time.Time `json:"last_sync" bson:"last_sync"`
+}
+
+func (s *SyncState) CollectionName() string {
+	return "data_gov_in_sync_states"
+}
@@ -0,0 +1,196 @@
+package datagovin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DatasetRevision is a fetched version of a dataset. A new revision is kept
+// every time the dataset changes on data.gov.in so that earlier versions can
+// still be cited
+type DatasetRevision struct {
+	mgm.DefaultModel `json:"-"`
+	DID              uint64 `json:"d_id" bson:"d_id"`
+	CatID            uint64 `json:"cat_id" bson:"cat_id"`
+	// Version numbers the revisions of a dataset from 1
+	Version      int                    `json:"version" bson:"version"`
+	Title        string                 `json:"title" bson:"title"`
+	LastModified time.Time              `json:"last_modified" bson:"last_modified"`
+	FetchedAt    time.Time              `json:"fetched_at" bson:"fetched_at"`
+	Hash         string                 `json:"hash" bson:"hash"`
+	Other        map[string]interface{} `json:"other" bson:"other"`
+	Data         Data                   `json:"data" bson:"data"`
+}
+
+func (r *DatasetRevision) CollectionName() string {
+	return "data_gov_in_dataset_revisions"
+}
+
+// NewRevision returns the revision holding the current state of the dataset
+func NewRevision(d *Dataset) (*DatasetRevision, error) {
+	hash, err := d.Data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &DatasetRevision{
+		DID:          d.DID,
+		CatID:        d.CatID,
+		Title:        d.Title,
+		LastModified: d.LastModified,
+		FetchedAt:    time.Now(),
+		Hash:         hash,
+		Other:        d.Other,
+		Data:         d.Data,
+	}, nil
+}
+
+// Revisions returns the revisions of the dataset, oldest first
+func Revisions(dID uint64, nodata bool) ([]*DatasetRevision, error) {
+	coll := mgm.Coll(&DatasetRevision{})
+	ctx := mgm.Ctx()
+
+	opts := options.Find().SetSort(bson.M{"version": 1})
+	if nodata {
+		opts.SetProjection(bson.M{"data": 0})
+	}
+	cur, err := coll.Find(ctx, bson.M{"d_id": dID}, opts)
+	if err != nil {
+		return []*DatasetRevision{}, fmt.Errorf("could not fetch revisions: %s", err)
+	}
+	var revisions []*DatasetRevision
+	err = cur.All(ctx, &revisions)
+	if err != nil {
+		return []*DatasetRevision{}, fmt.Errorf("could not decode revisions: %s", err)
+	}
+	return revisions, nil
+}
+
+// Revision returns a version of the dataset
+func Revision(dID uint64, version int) (*DatasetRevision, error) {
+	revision := &DatasetRevision{}
+	err := mgm.Coll(revision).First(bson.M{"d_id": dID, "version": version}, revision)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch revision %d of %d: %s", version, dID, err)
+	}
+	return revision, nil
+}
+
+// CellChange is a value that differs between two revisions
+type CellChange struct {
+	Row   int         `json:"row"`
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// RevisionDiff lists what changed between two revisions of a dataset. Rows
+// are matched by position and values by field id
+type RevisionDiff struct {
+	DID           uint64          `json:"d_id"`
+	From          int             `json:"from"`
+	To            int             `json:"to"`
+	FromHash      string          `json:"from_hash"`
+	ToHash        string          `json:"to_hash"`
+	OldTitle      string          `json:"old_title,omitempty"`
+	NewTitle      string          `json:"new_title,omitempty"`
+	AddedFields   []string        `json:"added_fields"`
+	RemovedFields []string        `json:"removed_fields"`
+	ChangedCells  []*CellChange   `json:"changed_cells"`
+	AddedRows     [][]interface{} `json:"added_rows"`
+	RemovedRows   [][]interface{} `json:"removed_rows"`
+}
+
+// Identical tells whether the revisions hold the same title and data
+func (d *RevisionDiff) Identical() bool {
+	return d.FromHash == d.ToHash && d.OldTitle == d.NewTitle
+}
+
+// fieldID names a field by its id, falling back to its label
+func fieldID(field map[string]string) string {
+	if id, ok := field["id"]; ok && id != "" {
+		return id
+	}
+	return field["label"]
+}
+
+func fieldIndex(fields []map[string]string) map[string]int {
+	index := make(map[string]int)
+	for i, f := range fields {
+		index[fieldID(f)] = i
+	}
+	return index
+}
+
+func cell(row []interface{}, i int) interface{} {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
+
+// Diff compares revision from with revision to
+func Diff(from, to *DatasetRevision) *RevisionDiff {
+	diff := &RevisionDiff{
+		DID:           to.DID,
+		From:          from.Version,
+		To:            to.Version,
+		FromHash:      from.Hash,
+		ToHash:        to.Hash,
+		AddedFields:   make([]string, 0),
+		RemovedFields: make([]string, 0),
+		ChangedCells:  make([]*CellChange, 0),
+		AddedRows:     make([][]interface{}, 0),
+		RemovedRows:   make([][]interface{}, 0),
+	}
+	if from.Title != to.Title {
+		diff.OldTitle = from.Title
+		diff.NewTitle = to.Title
+	}
+	if from.Hash == to.Hash {
+		return diff
+	}
+
+	fromFields := fieldIndex(from.Data.Fields)
+	toFields := fieldIndex(to.Data.Fields)
+	for _, f := range to.Data.Fields {
+		if _, ok := fromFields[fieldID(f)]; !ok {
+			diff.AddedFields = append(diff.AddedFields, fieldID(f))
+		}
+	}
+	for _, f := range from.Data.Fields {
+		if _, ok := toFields[fieldID(f)]; !ok {
+			diff.RemovedFields = append(diff.RemovedFields, fieldID(f))
+		}
+	}
+
+	rows := len(from.Data.Entries)
+	if len(to.Data.Entries) < rows {
+		rows = len(to.Data.Entries)
+	}
+	for row := 0; row < rows; row++ {
+		for _, f := range to.Data.Fields {
+			id := fieldID(f)
+			i, ok := fromFields[id]
+			if !ok {
+				continue
+			}
+			oldValue := cell(from.Data.Entries[row], i)
+			newValue := cell(to.Data.Entries[row], toFields[id])
+			if canonicalValue(oldValue) != canonicalValue(newValue) {
+				diff.ChangedCells = append(diff.ChangedCells, &CellChange{
+					Row:   row,
+					Field: id,
+					Old:   oldValue,
+					New:   newValue,
+				})
+			}
+		}
+	}
+	diff.AddedRows = append(diff.AddedRows, to.Data.Entries[rows:]...)
+	diff.RemovedRows = append(diff.RemovedRows, from.Data.Entries[rows:]...)
+	return diff
+}
@@ -0,0 +1,91 @@
+package datagovin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SyncLock is held by the sync daemon running a pipeline so that two daemons
+// never run at once. A lock past ExpiresAt is free to take over
+type SyncLock struct {
+	mgm.DefaultModel `json:"-"`
+	Name             string    `json:"name" bson:"name"`
+	Owner            string    `json:"owner" bson:"owner"`
+	AcquiredAt       time.Time `json:"acquired_at" bson:"acquired_at"`
+	ExpiresAt        time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+func (l *SyncLock) CollectionName() string {
+	return "data_gov_in_sync_locks"
+}
+
+// RunState is the outcome of a run of the sync daemon
+type RunState string
+
+const (
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+	// RunSkipped is a run that did not start as another daemon held the lock
+	RunSkipped RunState = "skipped"
+)
+
+// RunStep is a step of the pipeline of a run
+type RunStep struct {
+	Name       string    `json:"name" bson:"name"`
+	StartedAt  time.Time `json:"started_at" bson:"started_at"`
+	FinishedAt time.Time `json:"finished_at" bson:"finished_at"`
+	Skipped    bool      `json:"skipped,omitempty" bson:"skipped,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// SyncRun is a scheduled run of the pipeline of a topic by the sync daemon
+type SyncRun struct {
+	mgm.DefaultModel `json:"-"`
+	Topic            string     `json:"topic" bson:"topic"`
+	Schedule         string     `json:"schedule" bson:"schedule"`
+	Owner            string     `json:"owner" bson:"owner"`
+	State            RunState   `json:"state" bson:"state"`
+	StartedAt        time.Time  `json:"started_at" bson:"started_at"`
+	FinishedAt       time.Time  `json:"finished_at" bson:"finished_at"`
+	Steps            []*RunStep `json:"steps" bson:"steps"`
+	Catalogs         int        `json:"catalogs" bson:"catalogs"`
+	Datasets         int        `json:"datasets" bson:"datasets"`
+	FailedCatalogs   int        `json:"failed_catalogs" bson:"failed_catalogs"`
+	FailedDatasets   int        `json:"failed_datasets" bson:"failed_datasets"`
+	Error            string     `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+func (r *SyncRun) CollectionName() string {
+	return "data_gov_in_sync_runs"
+}
+
+// SyncRuns returns the latest runs of the topic, of every topic when it is
+// empty, newest first
+func SyncRuns(topic string, limit int64) ([]*SyncRun, error) {
+	coll := mgm.Coll(&SyncRun{})
+	ctx := mgm.Ctx()
+
+	filter := bson.M{}
+	if topic != "" {
+		filter["topic"] = topic
+	}
+	opts := options.Find().SetSort(bson.M{"started_at": -1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return []*SyncRun{}, fmt.Errorf("could not fetch sync runs: %s", err)
+	}
+	runs := make([]*SyncRun, 0)
+	err = cur.All(ctx, &runs)
+	if err != nil {
+		return []*SyncRun{}, fmt.Errorf("could not decode sync runs: %s", err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,12 @@
+package aishe
+
+import (
+	"github.com/spf13/cobra"
+	datagovin "github.com/zeu5/visualizations/scripts/data.gov.in"
+)
+
+// AisheCmd mirrors the higher education reports of aishe.gov.in through the
+// fetch pipeline of data.gov.in
+func AisheCmd() *cobra.Command {
+	return datagovin.TopicCmd("aishe", "Fetch/dump/summarise higher education records from aishe.gov.in", AisheProfile)
+}
@@ -0,0 +1,144 @@
+// Package fake serves a small in-memory imitation of the report API of
+// aishe.gov.in for exercising the source and recording fixtures without a
+// network. The API is the one assumed by the source, it has not been checked
+// against the portal
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Paths of the portal. They mirror the ones of the source, which cannot be
+// imported here as its tests import this package
+const (
+	yearsPath        = "/aishe/api/years"
+	statesPath       = "/aishe/api/states"
+	institutionsPath = "/aishe/api/institutions"
+	enrolmentPath    = "/aishe/api/enrolment"
+)
+
+// Institutions is a row of the institution report, the totals of a state
+// have no district
+type Institutions struct {
+	District     string `json:"district"`
+	Universities int    `json:"universities"`
+	Colleges     int    `json:"colleges"`
+	Standalone   int    `json:"standalone"`
+}
+
+// Enrolment is a row of the enrolment report
+type Enrolment struct {
+	District string `json:"district"`
+	Level    string `json:"level"`
+	Male     int    `json:"male"`
+	Female   int    `json:"female"`
+	Total    int    `json:"total"`
+}
+
+// Report holds the reports of a state in a survey year
+type Report struct {
+	Institutions []*Institutions
+	Enrolment    []*Enrolment
+}
+
+// State is a state served by the portal with its reports by the first year
+// of the survey
+type State struct {
+	Code    string
+	Name    string
+	Reports map[int]*Report
+}
+
+// Portal answers the years, states and report requests the way aishe.gov.in
+// does
+type Portal struct {
+	States []*State
+}
+
+func NewPortal(states ...*State) *Portal {
+	return &Portal{States: states}
+}
+
+// NewServer starts serving the portal, the caller must Close the server
+func NewServer(p *Portal) *httptest.Server {
+	return httptest.NewServer(p)
+}
+
+func respond(w http.ResponseWriter, records interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "ok",
+		"records": records,
+	})
+}
+
+func (p *Portal) years() []string {
+	seen := make(map[int]bool)
+	years := make([]int, 0)
+	for _, s := range p.States {
+		for year := range s.Reports {
+			if !seen[year] {
+				seen[year] = true
+				years = append(years, year)
+			}
+		}
+	}
+	sort.Ints(years)
+	records := make([]string, len(years))
+	for i, year := range years {
+		records[i] = fmt.Sprintf("%d-%02d", year, (year+1)%100)
+	}
+	return records
+}
+
+// report returns the report asked for by the year and state parameters
+func (p *Portal) report(r *http.Request) (*Report, bool) {
+	year, err := strconv.Atoi(strings.SplitN(r.URL.Query().Get("year"), "-", 2)[0])
+	if err != nil {
+		return nil, false
+	}
+	code := r.URL.Query().Get("state")
+	for _, s := range p.States {
+		if s.Code == code {
+			report, ok := s.Reports[year]
+			return report, ok
+		}
+	}
+	return nil, false
+}
+
+func (p *Portal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case yearsPath:
+		respond(w, p.years())
+	case statesPath:
+		states := make([]map[string]string, len(p.States))
+		for i, s := range p.States {
+			states[i] = map[string]string{"code": s.Code, "name": s.Name}
+		}
+		respond(w, states)
+	case institutionsPath, enrolmentPath:
+		report, ok := p.report(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == institutionsPath {
+			respond(w, report.Institutions)
+		} else {
+			respond(w, report.Enrolment)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
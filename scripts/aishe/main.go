@@ -0,0 +1,262 @@
+package aishe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kamva/mgm/v3"
+	"github.com/zeu5/visualizations/models/aishe"
+	models "github.com/zeu5/visualizations/models/data.gov.in"
+	datagovin "github.com/zeu5/visualizations/scripts/data.gov.in"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AisheProfile is the topic of the institution and enrolment reports of
+// aishe.gov.in. Its catalogs are the survey years, which filters select with
+// other.year, such as other.year="2019-20"
+var AisheProfile = &datagovin.Profile{
+	Name:        "aishe",
+	Description: "Higher education institutions and enrolment of the All India Survey on Higher Education",
+	Source:      SourceName,
+	Summarise:   true,
+}
+
+func init() {
+	datagovin.RegisterTopic(AisheProfile, Summarise)
+}
+
+// institutions converts the report of the state, adding the totals of the
+// state when the report only lists its districts
+func institutions(year int, state *State, records []*InstitutionRecord) []*aishe.Institution {
+	rows := make([]*aishe.Institution, 0, len(records)+1)
+	total := &aishe.Institution{Year: year, State: state.Name, StateCode: state.Code}
+	hasTotal := false
+	for _, r := range records {
+		rows = append(rows, &aishe.Institution{
+			Year:         year,
+			State:        state.Name,
+			StateCode:    state.Code,
+			District:     strings.TrimSpace(r.District),
+			Universities: r.Universities,
+			Colleges:     r.Colleges,
+			Standalone:   r.Standalone,
+		})
+		if strings.TrimSpace(r.District) == "" {
+			hasTotal = true
+			continue
+		}
+		total.Universities = total.Universities + r.Universities
+		total.Colleges = total.Colleges + r.Colleges
+		total.Standalone = total.Standalone + r.Standalone
+	}
+	if !hasTotal && len(records) != 0 {
+		rows = append(rows, total)
+	}
+	return rows
+}
+
+// enrolments converts the report of the state, adding the totals of each
+// level for the state when the report only lists its districts
+func enrolments(year int, state *State, records []*EnrolmentRecord) []*aishe.Enrolment {
+	rows := make([]*aishe.Enrolment, 0, len(records))
+	totals := make(map[string]*aishe.Enrolment)
+	levels := make([]string, 0)
+	hasTotal := make(map[string]bool)
+	for _, r := range records {
+		total := r.Total
+		if total == 0 {
+			total = r.Male + r.Female
+		}
+		row := &aishe.Enrolment{
+			Year:      year,
+			State:     state.Name,
+			StateCode: state.Code,
+			District:  strings.TrimSpace(r.District),
+			Level:     strings.TrimSpace(r.Level),
+			Male:      r.Male,
+			Female:    r.Female,
+			Total:     total,
+		}
+		rows = append(rows, row)
+		if row.District == "" {
+			hasTotal[row.Level] = true
+			continue
+		}
+		t, ok := totals[row.Level]
+		if !ok {
+			t = &aishe.Enrolment{Year: year, State: state.Name, StateCode: state.Code, Level: row.Level}
+			totals[row.Level] = t
+			levels = append(levels, row.Level)
+		}
+		t.Male = t.Male + row.Male
+		t.Female = t.Female + row.Female
+		t.Total = t.Total + row.Total
+	}
+	for _, level := range levels {
+		if !hasTotal[level] {
+			rows = append(rows, totals[level])
+		}
+	}
+	return rows
+}
+
+// SaveInstitutions replaces the institution counts of the state in the year
+func SaveInstitutions(year int, state *State, rows []*aishe.Institution) error {
+	coll := mgm.Coll(&aishe.Institution{})
+	_, err := coll.DeleteMany(mgm.Ctx(), bson.M{"year": year, "state_code": state.Code})
+	if err != nil {
+		return fmt.Errorf("could not replace institutions: %s", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(rows))
+	for i, r := range rows {
+		docs[i] = r
+	}
+	_, err = coll.InsertMany(mgm.Ctx(), docs)
+	if err != nil {
+		return fmt.Errorf("could not save institutions: %s", err)
+	}
+	return nil
+}
+
+// SaveEnrolments replaces the enrolment counts of the state in the year
+func SaveEnrolments(year int, state *State, rows []*aishe.Enrolment) error {
+	coll := mgm.Coll(&aishe.Enrolment{})
+	_, err := coll.DeleteMany(mgm.Ctx(), bson.M{"year": year, "state_code": state.Code})
+	if err != nil {
+		return fmt.Errorf("could not replace enrolments: %s", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(rows))
+	for i, r := range rows {
+		docs[i] = r
+	}
+	_, err = coll.InsertMany(mgm.Ctx(), docs)
+	if err != nil {
+		return fmt.Errorf("could not save enrolments: %s", err)
+	}
+	return nil
+}
+
+// toInt reads a count of a table, which Mongo returns as int32 or int64
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// rows returns the entries of the table by field id
+func rows(data models.Data) []map[string]interface{} {
+	rows := make([]map[string]interface{}, len(data.Entries))
+	for i, entry := range data.Entries {
+		row := make(map[string]interface{})
+		for j, field := range data.Fields {
+			if j < len(entry) {
+				row[field["id"]] = entry[j]
+			}
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// institutionRecords reads the institution report back from its table
+func institutionRecords(data models.Data) []*InstitutionRecord {
+	records := make([]*InstitutionRecord, 0, len(data.Entries))
+	for _, row := range rows(data) {
+		district, _ := row["district"].(string)
+		records = append(records, &InstitutionRecord{
+			District:     district,
+			Universities: toInt(row["universities"]),
+			Colleges:     toInt(row["colleges"]),
+			Standalone:   toInt(row["standalone"]),
+		})
+	}
+	return records
+}
+
+// enrolmentRecords reads the enrolment report back from its table
+func enrolmentRecords(data models.Data) []*EnrolmentRecord {
+	records := make([]*EnrolmentRecord, 0, len(data.Entries))
+	for _, row := range rows(data) {
+		district, _ := row["district"].(string)
+		level, _ := row["level"].(string)
+		records = append(records, &EnrolmentRecord{
+			District: district,
+			Level:    level,
+			Male:     toInt(row["male"]),
+			Female:   toInt(row["female"]),
+			Total:    toInt(row["total"]),
+		})
+	}
+	return records
+}
+
+// summariseReport replaces the institution or enrolment counts of the state
+// in the year with the report held by the dataset
+func summariseReport(report *stateReport, data models.Data) error {
+	if report.kind == InstitutionsReport {
+		return SaveInstitutions(report.year, report.state, institutions(report.year, report.state, institutionRecords(data)))
+	}
+	return SaveEnrolments(report.year, report.state, enrolments(report.year, report.state, enrolmentRecords(data)))
+}
+
+// Summarise replaces the institution and enrolment counts with the saved
+// reports of the profile
+func Summarise(ctx context.Context, p *datagovin.Profile) error {
+	catalogs, err := datagovin.ProfileCatalogs(p)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, c := range catalogs {
+		datasets, err := datagovin.GetCatalogInfo(c)
+		if err != nil {
+			return fmt.Errorf("could not fetch datasets: %s", err)
+		}
+		for _, d := range datasets {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Merged duplicates hold no data of their own
+			if d.DuplicateOf != 0 {
+				continue
+			}
+			report, err := datasetReport(d)
+			if err == nil {
+				err = summariseReport(report, d.Data)
+			}
+			if err == nil {
+				continue
+			}
+			if failed == 0 {
+				fmt.Fprintln(w, "DATASET\tERROR")
+			}
+			failed = failed + 1
+			fmt.Fprintf(w, "%s\t%s\n", d.Title, err)
+		}
+	}
+	w.Flush()
+	if failed != 0 {
+		return fmt.Errorf("failed to summarise %d reports", failed)
+	}
+	fmt.Println("Completed!")
+	return nil
+}
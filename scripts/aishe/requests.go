@@ -0,0 +1,395 @@
+package aishe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/source"
+	"github.com/zeu5/visualizations/util"
+)
+
+// BaseURL is the aishe.gov.in portal, the paths below are relative to it
+const BaseURL = "https://aishe.gov.in"
+
+// Paths of the report API of the portal. Reports of a state are requested
+// with the year and state query parameters. The portal does not document
+// them, they are the ones of the fake portal and have not been checked
+// against aishe.gov.in
+const (
+	YearsPath        = "/aishe/api/years"
+	StatesPath       = "/aishe/api/states"
+	InstitutionsPath = "/aishe/api/institutions"
+	EnrolmentPath    = "/aishe/api/enrolment"
+)
+
+// Lane is the ThrottledClient lane used for aishe.gov.in
+const Lane = "aishe.gov.in"
+
+var (
+	// ErrNotOK is returned for responses whose status is not ok
+	ErrNotOK = errors.New("response status not ok")
+	// ErrNotFound is returned for requests the portal has nothing for
+	ErrNotFound = errors.New("not found on the portal")
+)
+
+// SourceName is the name aishe.gov.in is registered under as a source
+const SourceName = "aishe.gov.in"
+
+func init() {
+	source.Register(SourceName, func(c source.Config) (source.Source, error) {
+		return newRequests(c)
+	})
+}
+
+// requests is the aishe.gov.in source. Catalogs are the survey years and
+// each state has an institutions and an enrolment dataset in every year
+type requests struct {
+	network *util.ThrottledClient
+	baseURL string
+}
+
+// newRequests creates the source, it does not support PageSize as the
+// portal does not page its reports
+func newRequests(c source.Config) (*requests, error) {
+	network, baseURL, err := c.NewClient(source.Client{BaseURL: BaseURL, Lane: Lane})
+	if err != nil {
+		return nil, err
+	}
+	return &requests{network: network, baseURL: baseURL}, nil
+}
+
+func (r *requests) Start() {
+	r.network.Start()
+}
+
+func (r *requests) Stop() {
+	r.network.Stop()
+}
+
+// surveyYear formats the academic year starting in year the way the portal
+// does, 2019-20 for 2019
+func surveyYear(year int) string {
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// parseSurveyYear returns the first year of a survey year such as 2019-20
+func parseSurveyYear(s string) (int, error) {
+	year, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(s), "-", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid survey year %q", s)
+	}
+	return year, nil
+}
+
+// get requests the path and decodes the records of the response into records
+func (r *requests) get(ctx context.Context, path string, query url.Values, records interface{}) error {
+	u := r.baseURL + path
+	if len(query) != 0 {
+		u = u + "?" + query.Encode()
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.network.DoContext(ctx, request, util.OnLane(Lane)).Wait(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read response: %s", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	page := struct {
+		Status  string          `json:"status"`
+		Records json.RawMessage `json:"records"`
+	}{}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return fmt.Errorf("could not parse response: %s", err)
+	}
+	if page.Status != "ok" {
+		return ErrNotOK
+	}
+	if err := json.Unmarshal(page.Records, records); err != nil {
+		return fmt.Errorf("could not parse records: %s", err)
+	}
+	return nil
+}
+
+// FetchYears returns the survey years published by the portal
+func (r *requests) FetchYears(ctx context.Context) ([]int, error) {
+	var records []string
+	if err := r.get(ctx, YearsPath, nil, &records); err != nil {
+		return []int{}, err
+	}
+	years := make([]int, 0, len(records))
+	for _, s := range records {
+		year, err := parseSurveyYear(s)
+		if err != nil {
+			return []int{}, err
+		}
+		years = append(years, year)
+	}
+	return years, nil
+}
+
+// State is a state or union territory surveyed
+type State struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// FetchStates returns the states surveyed
+func (r *requests) FetchStates(ctx context.Context) ([]*State, error) {
+	states := make([]*State, 0)
+	if err := r.get(ctx, StatesPath, nil, &states); err != nil {
+		return []*State{}, err
+	}
+	return states, nil
+}
+
+// getReport requests the report of the state in the year, records are left
+// empty when the state has not reported the year
+func (r *requests) getReport(ctx context.Context, path string, year int, state *State, records interface{}) error {
+	query := make(url.Values)
+	query.Set("year", surveyYear(year))
+	query.Set("state", state.Code)
+	if err := r.get(ctx, path, query, records); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// InstitutionRecord is a row of the institution report of a state, the
+// totals of the state have no district
+type InstitutionRecord struct {
+	District     string `json:"district"`
+	Universities int    `json:"universities"`
+	Colleges     int    `json:"colleges"`
+	Standalone   int    `json:"standalone"`
+}
+
+// FetchInstitutions returns the institution report of the state in the year,
+// which is empty when the state has not reported it
+func (r *requests) FetchInstitutions(ctx context.Context, year int, state *State) ([]*InstitutionRecord, error) {
+	records := make([]*InstitutionRecord, 0)
+	if err := r.getReport(ctx, InstitutionsPath, year, state, &records); err != nil {
+		return []*InstitutionRecord{}, err
+	}
+	return records, nil
+}
+
+// EnrolmentRecord is a row of the enrolment report of a state
+type EnrolmentRecord struct {
+	District string `json:"district"`
+	Level    string `json:"level"`
+	Male     int    `json:"male"`
+	Female   int    `json:"female"`
+	Total    int    `json:"total"`
+}
+
+// FetchEnrolment returns the enrolment report of the state in the year, which
+// is empty when the state has not reported it
+func (r *requests) FetchEnrolment(ctx context.Context, year int, state *State) ([]*EnrolmentRecord, error) {
+	records := make([]*EnrolmentRecord, 0)
+	if err := r.getReport(ctx, EnrolmentPath, year, state, &records); err != nil {
+		return []*EnrolmentRecord{}, err
+	}
+	return records, nil
+}
+
+// Provenance describes aishe.gov.in
+func (r *requests) Provenance() source.Provenance {
+	return source.Provenance{
+		Name:      SourceName,
+		URL:       r.baseURL,
+		Publisher: "Ministry of Education, Government of India",
+		// The portal states no license for its reports
+		License: "",
+	}
+}
+
+// Reports of a state, the report of a dataset is kept under reportKey in
+// its Other fields along with yearKey, stateKey and stateCodeKey
+const (
+	InstitutionsReport = "institutions"
+	EnrolmentReport    = "enrolment"
+
+	reportKey    = "report"
+	yearKey      = "year"
+	stateKey     = "state"
+	stateCodeKey = "state_code"
+)
+
+var reportTitles = map[string]string{
+	InstitutionsReport: "Institutions",
+	EnrolmentReport:    "Enrolment",
+}
+
+// itemID derives the id of a catalog or dataset from its key as the portal
+// has none. The ids are kept above the ones of data.gov.in and below 2^63 as
+// Mongo stores them as signed integers
+func itemID(key ...string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(key, "/")))
+	return 1<<62 | h.Sum64()&(1<<62-1)
+}
+
+// surveyCatalog is the catalog of the reports of the survey year
+func surveyCatalog(year int) *models.Catalog {
+	return &models.Catalog{
+		Title:       "All India Survey on Higher Education " + surveyYear(year),
+		CatID:       itemID(yearKey, surveyYear(year)),
+		Departments: []string{"Ministry of Education"},
+		Other:       map[string]interface{}{yearKey: surveyYear(year)},
+	}
+}
+
+// ListCatalogs returns the survey years whose title contains search. The
+// portal publishes no modification times, a survey year is fetched once
+func (r *requests) ListCatalogs(ctx context.Context, search string) ([]*models.Catalog, error) {
+	years, err := r.FetchYears(ctx)
+	if err != nil {
+		return []*models.Catalog{}, err
+	}
+	catalogs := make([]*models.Catalog, 0, len(years))
+	for _, year := range years {
+		c := surveyCatalog(year)
+		if strings.Contains(strings.ToLower(c.Title), strings.ToLower(search)) {
+			catalogs = append(catalogs, c)
+		}
+	}
+	return catalogs, nil
+}
+
+// ListCatalogsChangedSince lists every survey year, the portal can not tell
+// which changed
+func (r *requests) ListCatalogsChangedSince(ctx context.Context, search string, since time.Time) ([]*models.Catalog, error) {
+	return r.ListCatalogs(ctx, search)
+}
+
+// ListDatasets returns the reports of every state in the survey year of the
+// catalog
+func (r *requests) ListDatasets(ctx context.Context, c *models.Catalog) ([]*models.Dataset, error) {
+	yearS, _ := c.Other[yearKey].(string)
+	year, err := parseSurveyYear(yearS)
+	if err != nil {
+		return []*models.Dataset{}, err
+	}
+	states, err := r.FetchStates(ctx)
+	if err != nil {
+		return []*models.Dataset{}, err
+	}
+	datasets := make([]*models.Dataset, 0, 2*len(states))
+	for _, s := range states {
+		for _, report := range []string{InstitutionsReport, EnrolmentReport} {
+			datasets = append(datasets, &models.Dataset{
+				DID:   itemID(report, surveyYear(year), s.Code),
+				Title: fmt.Sprintf("%s of %s in %s", reportTitles[report], s.Name, surveyYear(year)),
+				CatID: c.CatID,
+				Other: map[string]interface{}{
+					reportKey:    report,
+					yearKey:      surveyYear(year),
+					stateKey:     s.Name,
+					stateCodeKey: s.Code,
+				},
+			})
+		}
+	}
+	return datasets, nil
+}
+
+// FetchData fetches the report of the dataset
+func (r *requests) FetchData(ctx context.Context, d *models.Dataset) (*models.Data, error) {
+	report, err := datasetReport(d)
+	if err != nil {
+		return nil, err
+	}
+	switch report.kind {
+	case InstitutionsReport:
+		records, err := r.FetchInstitutions(ctx, report.year, report.state)
+		if err != nil {
+			return nil, err
+		}
+		return institutionData(records), nil
+	default:
+		records, err := r.FetchEnrolment(ctx, report.year, report.state)
+		if err != nil {
+			return nil, err
+		}
+		return enrolmentData(records), nil
+	}
+}
+
+// stateReport is the report of a state in a survey year held by a dataset
+type stateReport struct {
+	kind  string
+	year  int
+	state *State
+}
+
+// datasetReport reads the report of the dataset from its Other fields
+func datasetReport(d *models.Dataset) (*stateReport, error) {
+	kind, _ := d.Other[reportKey].(string)
+	if kind != InstitutionsReport && kind != EnrolmentReport {
+		return nil, fmt.Errorf("dataset %d holds no report", d.DID)
+	}
+	yearS, _ := d.Other[yearKey].(string)
+	year, err := parseSurveyYear(yearS)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	state.Name, _ = d.Other[stateKey].(string)
+	state.Code, _ = d.Other[stateCodeKey].(string)
+	return &stateReport{kind: kind, year: year, state: state}, nil
+}
+
+// fields returns the fields of a table from their id and label pairs
+func fields(idLabels ...string) []map[string]string {
+	fields := make([]map[string]string, len(idLabels)/2)
+	for i := range fields {
+		fields[i] = map[string]string{"id": idLabels[2*i], "label": idLabels[2*i+1]}
+	}
+	return fields
+}
+
+// institutionData lays the institution report out as a table
+func institutionData(records []*InstitutionRecord) *models.Data {
+	data := &models.Data{
+		Fields:  fields("district", "District", "universities", "Universities", "colleges", "Colleges", "standalone", "Standalone Institutions"),
+		Entries: make([][]interface{}, len(records)),
+	}
+	for i, r := range records {
+		data.Entries[i] = []interface{}{r.District, r.Universities, r.Colleges, r.Standalone}
+	}
+	return data
+}
+
+// enrolmentData lays the enrolment report out as a table
+func enrolmentData(records []*EnrolmentRecord) *models.Data {
+	data := &models.Data{
+		Fields:  fields("district", "District", "level", "Level", "male", "Male", "female", "Female", "total", "Total"),
+		Entries: make([][]interface{}, len(records)),
+	}
+	for i, r := range records {
+		data.Entries[i] = []interface{}{r.District, r.Level, r.Male, r.Female, r.Total}
+	}
+	return data
+}
@@ -0,0 +1,282 @@
+package aishe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zeu5/visualizations/models/aishe"
+	models "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/aishe/fake"
+	"github.com/zeu5/visualizations/scripts/source"
+	"github.com/zeu5/visualizations/scripts/source/sourcetest"
+)
+
+// testPortal serves 2018-19 and 2019-20, Kerala reporting its districts only
+// and Goa its totals as well
+func testPortal() *fake.Portal {
+	report := func(scale int) *fake.Report {
+		return &fake.Report{
+			Institutions: []*fake.Institutions{
+				{District: "North", Universities: 1 * scale, Colleges: 10 * scale, Standalone: 2 * scale},
+				{District: "South", Universities: 2 * scale, Colleges: 20 * scale, Standalone: 3 * scale},
+			},
+			Enrolment: []*fake.Enrolment{
+				{District: "North", Level: "Under Graduate", Male: 100 * scale, Female: 120 * scale},
+				{District: "South", Level: "Under Graduate", Male: 50 * scale, Female: 40 * scale, Total: 90 * scale},
+			},
+		}
+	}
+	goa := report(1)
+	goa.Institutions = append(goa.Institutions, &fake.Institutions{Universities: 3, Colleges: 30, Standalone: 5})
+	return fake.NewPortal(
+		&fake.State{Code: "KL", Name: "Kerala", Reports: map[int]*fake.Report{2018: report(1), 2019: report(2)}},
+		&fake.State{Code: "GA", Name: "Goa", Reports: map[int]*fake.Report{2019: goa}},
+	)
+}
+
+func newTestRequests(t *testing.T, c source.Config) *requests {
+	t.Helper()
+	r, err := newRequests(sourcetest.Config(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	return r
+}
+
+func TestFetchYearsAndStates(t *testing.T) {
+	srv := fake.NewServer(testPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+
+	years, err := r.FetchYears(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(years, []int{2018, 2019}) {
+		t.Fatalf("got years %v", years)
+	}
+	states, err := r.FetchStates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*State{{Code: "KL", Name: "Kerala"}, {Code: "GA", Name: "Goa"}}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("got states %+v %+v", states[0], states[1])
+	}
+}
+
+func TestFetchReports(t *testing.T) {
+	srv := fake.NewServer(testPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+	kerala := &State{Code: "KL", Name: "Kerala"}
+
+	institutions, err := r.FetchInstitutions(ctx, 2019, kerala)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInstitutions := []*InstitutionRecord{
+		{District: "North", Universities: 2, Colleges: 20, Standalone: 4},
+		{District: "South", Universities: 4, Colleges: 40, Standalone: 6},
+	}
+	if !reflect.DeepEqual(institutions, wantInstitutions) {
+		t.Fatalf("got institutions %+v %+v", institutions[0], institutions[1])
+	}
+	enrolment, err := r.FetchEnrolment(ctx, 2019, kerala)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The portal leaves out the total of North, it is not made up here
+	wantEnrolment := []*EnrolmentRecord{
+		{District: "North", Level: "Under Graduate", Male: 200, Female: 240},
+		{District: "South", Level: "Under Graduate", Male: 100, Female: 80, Total: 180},
+	}
+	if !reflect.DeepEqual(enrolment, wantEnrolment) {
+		t.Fatalf("got enrolment %+v %+v", enrolment[0], enrolment[1])
+	}
+}
+
+// TestFetchMissingReport reads a report the state has not published for the
+// year as empty
+func TestFetchMissingReport(t *testing.T) {
+	srv := fake.NewServer(testPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+	goa := &State{Code: "GA", Name: "Goa"}
+
+	institutions, err := r.FetchInstitutions(ctx, 2018, goa)
+	if err != nil || len(institutions) != 0 {
+		t.Fatalf("got %v, %v", institutions, err)
+	}
+	enrolment, err := r.FetchEnrolment(ctx, 2018, goa)
+	if err != nil || len(enrolment) != 0 {
+		t.Fatalf("got %v, %v", enrolment, err)
+	}
+	for _, kind := range []string{InstitutionsReport, EnrolmentReport} {
+		data, err := r.FetchData(ctx, &models.Dataset{Other: map[string]interface{}{
+			reportKey:    kind,
+			yearKey:      "2018-19",
+			stateKey:     goa.Name,
+			stateCodeKey: goa.Code,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Fields) == 0 || len(data.Entries) != 0 {
+			t.Fatalf("unexpected data of the missing %s report %+v", kind, data)
+		}
+	}
+	// Other paths missing from the portal still fail
+	if err := r.get(ctx, "/missing", nil, &[]string{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestListCatalogsAndDatasets(t *testing.T) {
+	srv := fake.NewServer(testPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+
+	catalogs, err := r.ListCatalogs(ctx, "2019-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalogs) != 1 || catalogs[0].Title != "All India Survey on Higher Education 2019-20" {
+		t.Fatalf("unexpected catalogs %+v", catalogs)
+	}
+	datasets, err := r.ListDatasets(ctx, catalogs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make([]string, len(datasets))
+	ids := make(map[uint64]bool)
+	for i, d := range datasets {
+		titles[i] = d.Title
+		ids[d.DID] = true
+		if d.DID >= 1<<63 || d.DID == catalogs[0].CatID || d.CatID != catalogs[0].CatID {
+			t.Fatalf("unexpected ids of %+v", d)
+		}
+	}
+	want := []string{
+		"Institutions of Kerala in 2019-20", "Enrolment of Kerala in 2019-20",
+		"Institutions of Goa in 2019-20", "Enrolment of Goa in 2019-20",
+	}
+	if !reflect.DeepEqual(titles, want) {
+		t.Fatalf("got datasets %v, want %v", titles, want)
+	}
+	if len(ids) != len(datasets) {
+		t.Fatalf("datasets share ids: %v", ids)
+	}
+}
+
+// fetchReports lists every survey year and fetches the data of its reports
+func fetchReports(t *testing.T, c source.Config) []*models.Dataset {
+	r := newTestRequests(t, c)
+	defer r.Stop()
+	ctx := context.Background()
+	catalogs, err := r.ListCatalogs(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]*models.Dataset, 0)
+	for _, c := range catalogs {
+		datasets, err := r.ListDatasets(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range datasets {
+			data, err := r.FetchData(ctx, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Data = *data
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// TestRecordReplay records the reports of the portal and summarises the
+// replayed ones into institution and enrolment counts
+func TestRecordReplay(t *testing.T) {
+	replayed := sourcetest.RecordReplay(t, testPortal(), func(c source.Config) interface{} {
+		return fetchReports(t, c)
+	}).([]*models.Dataset)
+	// Goa has no reports of 2018-19, they are kept empty
+	empty := 0
+	for _, d := range replayed {
+		if len(d.Data.Entries) == 0 {
+			empty = empty + 1
+		}
+	}
+	if len(replayed) != 8 || empty != 2 {
+		t.Fatalf("replayed %d reports, %d empty, want 8 and 2", len(replayed), empty)
+	}
+
+	var keralaInstitutions []*aishe.Institution
+	var goaInstitutions []*aishe.Institution
+	var keralaEnrolments []*aishe.Enrolment
+	for _, d := range replayed {
+		report, err := datasetReport(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.year != 2019 {
+			continue
+		}
+		switch {
+		case report.kind == InstitutionsReport && report.state.Code == "KL":
+			keralaInstitutions = institutions(report.year, report.state, institutionRecords(d.Data))
+		case report.kind == InstitutionsReport && report.state.Code == "GA":
+			goaInstitutions = institutions(report.year, report.state, institutionRecords(d.Data))
+		case report.kind == EnrolmentReport && report.state.Code == "KL":
+			keralaEnrolments = enrolments(report.year, report.state, enrolmentRecords(d.Data))
+		}
+	}
+
+	// Kerala reports its districts only, the totals of the state are added
+	if len(keralaInstitutions) != 3 {
+		t.Fatalf("got %d institution rows of Kerala, want 3", len(keralaInstitutions))
+	}
+	total := keralaInstitutions[2]
+	if total.District != "" || total.Year != 2019 || total.State != "Kerala" || total.StateCode != "KL" ||
+		total.Universities != 6 || total.Colleges != 60 || total.Standalone != 10 {
+		t.Fatalf("unexpected totals of Kerala %+v", total)
+	}
+	// Goa reports its totals, which are kept
+	if len(goaInstitutions) != 3 || goaInstitutions[2].Universities != 3 {
+		t.Fatalf("unexpected institution rows of Goa %+v", goaInstitutions)
+	}
+	if len(keralaEnrolments) != 3 {
+		t.Fatalf("got %d enrolment rows of Kerala, want 3", len(keralaEnrolments))
+	}
+	if north := keralaEnrolments[0]; north.Total != 440 {
+		t.Fatalf("totals missing from the report are the sum of male and female, got %+v", north)
+	}
+	if levelTotal := keralaEnrolments[2]; levelTotal.District != "" || levelTotal.Level != "Under Graduate" ||
+		levelTotal.Male != 300 || levelTotal.Female != 320 || levelTotal.Total != 620 {
+		t.Fatalf("unexpected totals of Kerala %+v", levelTotal)
+	}
+}
+
+// TestRecordsFromStoredData reads reports back the way Mongo returns them
+func TestRecordsFromStoredData(t *testing.T) {
+	data := institutionData([]*InstitutionRecord{{District: "North", Universities: 1, Colleges: 2, Standalone: 3}})
+	data.Entries[0] = []interface{}{"North", int32(1), int64(2), float64(3)}
+	records := institutionRecords(*data)
+	want := []*InstitutionRecord{{District: "North", Universities: 1, Colleges: 2, Standalone: 3}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("got %+v, want %+v", records[0], want[0])
+	}
+}
@@ -1,39 +1,333 @@
 package datagovin
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
 
-func CrimeCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "crime",
-		Short: "Fetch/dump Crime records from data.gov.in",
+	"github.com/spf13/cobra"
+)
+
+var (
+	profilesPath string
+	profileName  string
+)
+
+// selectedProfile returns the profile named by --profile
+func selectedProfile() (*Profile, error) {
+	profiles, err := LoadProfiles(profilesPath)
+	if err != nil {
+		return nil, err
 	}
-	cmd.PersistentFlags().StringVar(&dbURL, "mongo", "mongodb://localhost:27017", "MongoDB URI")
+	return profiles.Get(profileName)
+}
 
+func builtinProfile(p *Profile) func() (*Profile, error) {
+	return func() (*Profile, error) {
+		return p, nil
+	}
+}
+
+func parseID(arg string) uint64 {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid id: %s\n", arg)
+	}
+	return id
+}
+
+// addRequestFlags adds the flags configuring the requests to data.gov.in
+func addRequestFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().DurationVar(&fetchConfig.Timeout, "timeout", 10*time.Minute, "Deadline for each request once sent, 0 to disable")
+	cmd.PersistentFlags().IntVar(&fetchConfig.Retries, "retries", 5, "Maximum attempts for each request")
+	cmd.PersistentFlags().Float64Var(&fetchConfig.Rate, "rate", 5, "Maximum requests per second")
+	cmd.PersistentFlags().IntVar(&fetchConfig.Burst, "burst", 10, "Maximum requests sent at once")
+	cmd.PersistentFlags().StringVar(&fetchConfig.CacheDir, "cache", "", "Directory to cache responses in, disabled when empty")
+	cmd.PersistentFlags().DurationVar(&fetchConfig.CacheMaxAge, "cache-max-age", 0, "Serve cached responses younger than this without revalidating")
+	cmd.PersistentFlags().StringVar(&fetchConfig.BaseURL, "base-url", "", "URL of the portal to fetch from, the one of the source of the profile when empty")
+	cmd.PersistentFlags().IntVar(&fetchConfig.PageSize, "page-size", DefaultPageSize, "Records requested per page of catalog and dataset listings")
+}
+
+// checkRequestFlags rejects the request flags that would leave the portal
+// unthrottled
+func checkRequestFlags() error {
+	if fetchConfig.Rate <= 0 {
+		return fmt.Errorf("--rate must be positive, got %v", fetchConfig.Rate)
+	}
+	return nil
+}
+
+// newFetchCmd returns a fetch command mirroring the catalogs of the profile
+// unless the flags select others
+func newFetchCmd(short string, profile func() (*Profile, error)) *cobra.Command {
+	var query, filter string
 	fetchCmd := &cobra.Command{
 		Use:   "fetch",
-		Short: "Fetch Crime records from data.gov.in",
+		Short: short,
 		Run: func(cmd *cobra.Command, args []string) {
-			Fetch()
+			if err := checkRequestFlags(); err != nil {
+				log.Fatalln(err)
+			}
+			p, err := profile()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			selected := *p
+			if cmd.Flags().Changed("query") {
+				selected.Query = query
+			}
+			if cmd.Flags().Changed("filter") {
+				selected.Filter = filter
+			}
+			Fetch(&selected)
 		},
 	}
+	fetchCmd.PersistentFlags().StringVar(&query, "query", "", "Search the catalogs of the portal with this query instead of the one of the profile")
+	fetchCmd.PersistentFlags().StringVar(&filter, "filter", "", "Only fetch catalogs matching this expression instead of the filter of the profile, such as title~\"Census\" && department=\"Ministry of Home Affairs\"")
+	addRequestFlags(fetchCmd)
+	fetchCmd.PersistentFlags().BoolVar(&fetchConfig.Offline, "offline", false, "Serve every request from the cache, covering the listings and the token and export of every dataset fetched before with --cache")
+	fetchCmd.PersistentFlags().StringVar(&fetchConfig.RecordDir, "record", "", "Directory to record every response in as fixtures")
+	fetchCmd.PersistentFlags().StringVar(&fetchConfig.ReplayDir, "replay", "", "Directory of fixtures to answer every request from")
+	fetchCmd.PersistentFlags().BoolVar(&fetchIncremental, "incremental", false, "Only list the catalogs changed since the last complete run of the profile")
+	fetchCmd.PersistentFlags().StringVar(&fetchReport, "report", "", "JSONL file to write failures to, such as fetch-failures.jsonl, disabled when empty")
+	fetchCmd.PersistentFlags().BoolVar(&fetchRetryFailed, "retry-failed", false, "Only retry the catalogs and datasets listed in the failure report given with --report")
+	fetchCmd.PersistentFlags().BoolVar(&fetchResume, "resume", false, "Only fetch the catalogs and datasets left unfinished by earlier runs")
+	fetchCmd.PersistentFlags().BoolVar(&fetchDryRun, "dry-run", false, "Print the catalogs and datasets that would be fetched without saving or downloading anything")
+	fetchCmd.PersistentFlags().StringVar(&fetchPlan, "plan", "", "JSON file to write the plan of a dry run to instead of printing it")
+	fetchCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 10, "Maximum catalogs or datasets fetched at once")
+	return fetchCmd
+}
+
+func newDumpCmd(profile func() (*Profile, error)) *cobra.Command {
 	dumpCmd := &cobra.Command{
 		Use:   "dump",
 		Short: "Dump data in mongo as jsonl",
 		Run: func(cmd *cobra.Command, args []string) {
-			Dump()
+			p, err := profile()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			Dump(p)
 		},
 	}
-	summaryCmd := &cobra.Command{
-		Use:   "summary",
-		Short: "Summarize all data",
+	dumpCmd.PersistentFlags().StringVar(&dumpPath, "path", "dump", "Path to dump data at")
+	dumpCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 10, "Maximum catalogs read at once")
+	return dumpCmd
+}
+
+// DataGovInCmd mirrors the topics of data.gov.in described by profiles
+func DataGovInCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "datagovin",
+		Short: "Fetch/dump records of any topic from data.gov.in",
+	}
+	cmd.PersistentFlags().StringVar(&dbURL, "mongo", "mongodb://localhost:27017", "MongoDB URI")
+	cmd.PersistentFlags().StringVar(&profilesPath, "config", "", "JSON file with topic profiles in addition to the built-in ones")
+	cmd.PersistentFlags().StringVarP(&profileName, "profile", "p", CrimeProfile.Name, "Topic profile to work on")
+
+	profilesCmd := &cobra.Command{
+		Use:   "profiles",
+		Short: "List the topic profiles",
 		Run: func(cmd *cobra.Command, args []string) {
-			Summarise()
+			profiles, err := LoadProfiles(profilesPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			ListProfiles(profiles)
 		},
 	}
-	dumpCmd.PersistentFlags().StringVar(&dumpPath, "path", "dump", "Path to dump data at")
 
+	catalogsCmd := &cobra.Command{
+		Use:   "catalogs",
+		Short: "Inspect the saved catalogs",
+	}
+	catalogsCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the saved catalogs of the profile",
+		Run: func(cmd *cobra.Command, args []string) {
+			p, err := selectedProfile()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := ListCatalogs(p); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	})
+
+	catalogCmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "Inspect a saved catalog",
+	}
+	catalogCmd.AddCommand(&cobra.Command{
+		Use:   "show <catalog id>",
+		Short: "Show a saved catalog",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ShowCatalog(parseID(args[0])); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	})
+
+	datasetsCmd := &cobra.Command{
+		Use:   "datasets",
+		Short: "Inspect the saved datasets",
+	}
+	datasetsCmd.AddCommand(&cobra.Command{
+		Use:   "list <catalog id>",
+		Short: "List the saved datasets of a catalog",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ListDatasets(parseID(args[0])); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	})
+
+	datasetsCmd.AddCommand(&cobra.Command{
+		Use:   "revisions <dataset id>",
+		Short: "List the saved versions of a dataset",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ListRevisions(parseID(args[0])); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	})
+	datasetsCmd.AddCommand(&cobra.Command{
+		Use:   "diff <dataset id> <from version> <to version>",
+		Short: "Show the differences between two versions of a dataset",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			from, err := strconv.Atoi(args[1])
+			if err != nil {
+				log.Fatalf("Invalid version: %s\n", args[1])
+			}
+			to, err := strconv.Atoi(args[2])
+			if err != nil {
+				log.Fatalf("Invalid version: %s\n", args[2])
+			}
+			if err := DiffRevisions(parseID(args[0]), from, to); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	})
+
+	var merge bool
+	duplicatesCmd := &cobra.Command{
+		Use:   "duplicates",
+		Short: "Report the saved datasets of the profile with the same contents",
+		Run: func(cmd *cobra.Command, args []string) {
+			p, err := selectedProfile()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := Dedupe(p, merge); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+	duplicatesCmd.PersistentFlags().BoolVar(&merge, "merge", false, "Drop the data of the duplicates, keeping the dataset created first")
+	datasetsCmd.AddCommand(duplicatesCmd)
+
+	cmd.AddCommand(profilesCmd)
+	cmd.AddCommand(catalogsCmd)
+	cmd.AddCommand(catalogCmd)
+	cmd.AddCommand(datasetsCmd)
+	cmd.AddCommand(newFetchCmd("Fetch the catalogs of the profile from data.gov.in", selectedProfile))
+	cmd.AddCommand(newDumpCmd(selectedProfile))
+	return cmd
+}
+
+// TopicCmd fetches and dumps the catalogs of the built-in profile p, and
+// summarises them when the profile sets Summarise
+func TopicCmd(use, short string, p *Profile) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+	}
+	cmd.PersistentFlags().StringVar(&dbURL, "mongo", "mongodb://localhost:27017", "MongoDB URI")
+
+	fetchCmd := newFetchCmd("Fetch the catalogs of the "+p.Name+" profile", builtinProfile(p))
+	dumpCmd := newDumpCmd(builtinProfile(p))
 	cmd.AddCommand(fetchCmd)
 	cmd.AddCommand(dumpCmd)
-	cmd.AddCommand(summaryCmd)
+	if p.Summarise {
+		summaryCmd := &cobra.Command{
+			Use:   "summary",
+			Short: "Summarize all data",
+			Run: func(cmd *cobra.Command, args []string) {
+				Summarise(p)
+			},
+		}
+		summaryCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 10, "Maximum catalogs summarised at once")
+		cmd.AddCommand(summaryCmd)
+	}
+	return cmd
+}
+
+func CrimeCmd() *cobra.Command {
+	return TopicCmd("crime", "Fetch/dump Crime records from data.gov.in", CrimeProfile)
+}
+
+// SyncCmd keeps the topics of data.gov.in in sync on a schedule
+func SyncCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Keep the topics of data.gov.in in sync on a schedule",
+	}
+	cmd.PersistentFlags().StringVar(&dbURL, "mongo", "mongodb://localhost:27017", "MongoDB URI")
+	cmd.PersistentFlags().StringVar(&profilesPath, "config", "", "JSON file with topic profiles in addition to the built-in ones")
+
+	daemonCmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Fetch, summarise and expire the response cache of every scheduled profile on its schedule",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := checkRequestFlags(); err != nil {
+				log.Fatalln(err)
+			}
+			profiles, err := LoadProfiles(profilesPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			scheduled, err := scheduleProfiles(profiles, daemonProfiles, daemonSchedule)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fetchIncremental = daemonIncremental
+			fetchReport = daemonReport
+			ctx, cancel := interruptContext()
+			defer cancel()
+			if err := Daemon(ctx, scheduled); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+	daemonCmd.PersistentFlags().StringSliceVar(&daemonProfiles, "profiles", nil, "Profiles to run, every profile with a schedule when empty")
+	daemonCmd.PersistentFlags().StringVar(&daemonSchedule, "schedule", "", "Cron expression for the profiles without a schedule, such as \"0 3 * * *\"")
+	daemonCmd.PersistentFlags().DurationVar(&daemonLockTTL, "lock-ttl", 30*time.Minute, "Time after which the lock of a daemon that stopped renewing it can be taken over")
+	daemonCmd.PersistentFlags().BoolVar(&daemonIncremental, "incremental", true, "Only list the catalogs changed since the last complete run of the profile")
+	daemonCmd.PersistentFlags().StringVar(&daemonReport, "report", "", "JSONL file to write the failures of each run to, disabled when empty")
+	daemonCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 10, "Maximum catalogs or datasets fetched at once")
+	addRequestFlags(daemonCmd)
+
+	var topic string
+	var limit int64
+	runsCmd := &cobra.Command{
+		Use:   "runs",
+		Short: "List the latest runs of the sync daemon",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ListSyncRuns(topic, limit); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+	runsCmd.PersistentFlags().StringVarP(&topic, "profile", "p", "", "Only list the runs of this profile")
+	runsCmd.PersistentFlags().Int64Var(&limit, "limit", 20, "Maximum runs listed, 0 for all")
+
+	cmd.AddCommand(daemonCmd)
+	cmd.AddCommand(runsCmd)
 	return cmd
 }
@@ -0,0 +1,267 @@
+package datagovin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/util"
+)
+
+// syncLockName is the lock every daemon takes for a run, runs of different
+// topics share the journal and the rate limit of data.gov.in
+const syncLockName = "data.gov.in sync"
+
+// Steps of the pipeline of a run
+const (
+	StepFetch           = "fetch"
+	StepSummary         = "summary"
+	StepInvalidateCache = "invalidate-cache"
+)
+
+var (
+	daemonLockTTL     time.Duration
+	daemonSchedule    string
+	daemonProfiles    []string
+	daemonIncremental bool
+	daemonReport      string
+)
+
+type scheduledProfile struct {
+	profile  *Profile
+	schedule *util.Schedule
+	next     time.Time
+}
+
+// scheduleProfiles returns the named profiles with their schedule, every
+// profile with a schedule when names is empty. Profiles without a schedule
+// run on fallback
+func scheduleProfiles(profiles Profiles, names []string, fallback string) ([]*scheduledProfile, error) {
+	explicit := len(names) != 0
+	if !explicit {
+		names = profiles.Names()
+	}
+	scheduled := make([]*scheduledProfile, 0)
+	for _, name := range names {
+		p, err := profiles.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		expr := p.Schedule
+		if expr == "" {
+			expr = fallback
+		}
+		if expr == "" {
+			if explicit {
+				return nil, fmt.Errorf("profile %s has no schedule", name)
+			}
+			continue
+		}
+		schedule, err := util.ParseSchedule(expr)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %s", name, err)
+		}
+		scheduled = append(scheduled, &scheduledProfile{profile: p, schedule: schedule})
+	}
+	if len(scheduled) == 0 {
+		return nil, errors.New("no profile has a schedule, set one in the config or with --schedule")
+	}
+	return scheduled, nil
+}
+
+// Daemon runs the pipeline of every profile on its schedule until ctx is
+// done. Runs are recorded in the sync run history
+func Daemon(ctx context.Context, scheduled []*scheduledProfile) error {
+	if daemonLockTTL <= 0 {
+		return errors.New("the lock ttl must be positive")
+	}
+	if err := InitializeDB(dbURL); err != nil {
+		return fmt.Errorf("could not initialize to database: %s", err)
+	}
+	owner := lockOwner()
+	now := time.Now()
+	for _, s := range scheduled {
+		s.next = s.schedule.Next(now)
+	}
+	for {
+		var next *scheduledProfile
+		for _, s := range scheduled {
+			if s.next.IsZero() {
+				continue
+			}
+			if next == nil || s.next.Before(next.next) {
+				next = s
+			}
+		}
+		if next == nil {
+			return errors.New("no scheduled run left")
+		}
+		fmt.Printf("Next run: %s at %s\n", next.profile.Name, next.next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		run := runPipeline(ctx, next, owner)
+		if run.Error != "" {
+			fmt.Printf("Run of %s %s: %s\n", run.Topic, run.State, run.Error)
+		} else {
+			fmt.Printf("Run of %s %s\n", run.Topic, run.State)
+		}
+		next.next = next.schedule.Next(time.Now())
+	}
+}
+
+type pipelineStep struct {
+	name string
+	skip bool
+	run  func(ctx context.Context) error
+}
+
+// runPipeline fetches the profile, summarises it and expires the response
+// cache while holding the sync lock, recording the run as it goes
+func runPipeline(ctx context.Context, s *scheduledProfile, owner string) *datagovin.SyncRun {
+	run := &datagovin.SyncRun{
+		Topic:     s.profile.Name,
+		Schedule:  s.schedule.String(),
+		Owner:     owner,
+		State:     datagovin.RunRunning,
+		StartedAt: time.Now(),
+		Steps:     make([]*datagovin.RunStep, 0),
+	}
+	save := func() {
+		if err := SaveSyncRun(run); err != nil {
+			fmt.Printf("Failed to save the sync run: %s\n", err)
+		}
+	}
+
+	acquired, err := AcquireLock(syncLockName, owner, daemonLockTTL)
+	if err != nil || !acquired {
+		run.State = datagovin.RunSkipped
+		run.Error = "another daemon holds the sync lock"
+		if err != nil {
+			run.State = datagovin.RunFailed
+			run.Error = err.Error()
+		}
+		run.FinishedAt = time.Now()
+		save()
+		return run
+	}
+	defer func() {
+		if err := ReleaseLock(syncLockName, owner); err != nil {
+			fmt.Println(err)
+		}
+	}()
+	save()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lock := newLockKeeper(owner, daemonLockTTL)
+	go lock.keep(runCtx, cancel)
+
+	steps := []pipelineStep{
+		{name: StepFetch, run: func(ctx context.Context) error {
+			prog, err := fetch(ctx, s.profile)
+			if prog != nil {
+				run.Catalogs = prog.totCatalogs
+				run.Datasets = prog.totDat
+				run.FailedCatalogs = prog.failedCat
+				run.FailedDatasets = prog.failedDat
+			}
+			return err
+		}},
+		{name: StepSummary, skip: !s.profile.Summarise, run: func(ctx context.Context) error {
+			return summarise(ctx, s.profile)
+		}},
+		{name: StepInvalidateCache, skip: fetchConfig.CacheDir == "" || fetchConfig.CacheMaxAge <= 0, run: invalidateCache},
+	}
+	for _, p := range steps {
+		step := &datagovin.RunStep{Name: p.name, StartedAt: time.Now()}
+		run.Steps = append(run.Steps, step)
+		if p.skip {
+			step.Skipped = true
+			step.FinishedAt = step.StartedAt
+			continue
+		}
+		err := p.run(runCtx)
+		step.FinishedAt = time.Now()
+		if lost := lock.err(); lost != nil {
+			err = lost
+		} else if err == nil {
+			err = runCtx.Err()
+		}
+		if err != nil {
+			step.Error = err.Error()
+			run.State = datagovin.RunFailed
+			run.Error = fmt.Sprintf("%s: %s", p.name, err)
+			break
+		}
+		save()
+	}
+	if run.State == datagovin.RunRunning {
+		run.State = datagovin.RunSucceeded
+	}
+	run.FinishedAt = time.Now()
+	save()
+	return run
+}
+
+// invalidateCache expires the cached responses so that the next run
+// revalidates them all. Only the ones younger than --cache-max-age would be
+// served without revalidating, the cache is kept so that unchanged listings
+// and exports are not downloaded again
+func invalidateCache(ctx context.Context) error {
+	cache, err := util.NewCache(util.CacheOptions{Dir: fetchConfig.CacheDir})
+	if err != nil {
+		return err
+	}
+	return cache.Expire()
+}
+
+// lockKeeper renews the sync lock while a run holds it
+type lockKeeper struct {
+	owner string
+	ttl   time.Duration
+	lost  error
+	lock  *sync.Mutex
+}
+
+func newLockKeeper(owner string, ttl time.Duration) *lockKeeper {
+	return &lockKeeper{
+		owner: owner,
+		ttl:   ttl,
+		lock:  new(sync.Mutex),
+	}
+}
+
+// keep renews the lock every third of its ttl until ctx is done. The run is
+// cancelled once the lock can not be renewed
+func (k *lockKeeper) keep(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(k.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := RenewLock(syncLockName, k.owner, k.ttl); err != nil {
+			k.lock.Lock()
+			k.lost = err
+			k.lock.Unlock()
+			cancel()
+			return
+		}
+	}
+}
+
+func (k *lockKeeper) err() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.lost
+}
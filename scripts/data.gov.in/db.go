@@ -6,11 +6,29 @@ import (
 	"github.com/kamva/mgm/v3"
 	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// dbInitialized is the URL of the database connected to, the sync daemon
+// initializes it on every run
+var dbInitialized string
+
 func InitializeDB(url string) error {
-	return mgm.SetDefaultConfig(nil, "vis", options.Client().ApplyURI(url))
+	if url == dbInitialized {
+		return nil
+	}
+	if err := mgm.SetDefaultConfig(nil, "vis", options.Client().ApplyURI(url)); err != nil {
+		return err
+	}
+	if err := ensureRevisionIndex(); err != nil {
+		return err
+	}
+	if err := ensureLockIndex(); err != nil {
+		return err
+	}
+	dbInitialized = url
+	return nil
 }
 
 func GetAllCatalog() ([]*datagovin.Catalog, error) {
@@ -30,6 +48,15 @@ func GetAllCatalog() ([]*datagovin.Catalog, error) {
 	return catalogs, nil
 }
 
+func GetCatalog(catID uint64) (*datagovin.Catalog, error) {
+	c := &datagovin.Catalog{}
+	err := mgm.Coll(c).First(bson.M{"cat_id": catID}, c)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch catalog %d: %s", catID, err)
+	}
+	return c, nil
+}
+
 func GetCatalogInfo(c *datagovin.Catalog) ([]*datagovin.Dataset, error) {
 	coll := mgm.Coll(&datagovin.Dataset{})
 	ctx := mgm.Ctx()
@@ -58,3 +85,127 @@ func SaveDataset(d *datagovin.Dataset) error {
 	}
 	return mgm.Coll(d).Update(d)
 }
+
+// GetSyncState returns the sync state of the topic, a zero high-water mark
+// when it was never synced
+func GetSyncState(topic string) (*datagovin.SyncState, error) {
+	state := &datagovin.SyncState{Topic: topic}
+	err := mgm.Coll(state).First(bson.M{"topic": topic}, state)
+	if err == mongo.ErrNoDocuments {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch sync state: %s", err)
+	}
+	return state, nil
+}
+
+func SaveSyncState(s *datagovin.SyncState) error {
+	if s.ID.IsZero() {
+		return mgm.Coll(s).Create(s)
+	}
+	return mgm.Coll(s).Update(s)
+}
+
+func SaveSyncRun(r *datagovin.SyncRun) error {
+	if r.ID.IsZero() {
+		return mgm.Coll(r).Create(r)
+	}
+	return mgm.Coll(r).Update(r)
+}
+
+func SaveChanges(changes []*datagovin.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(changes))
+	for i, c := range changes {
+		docs[i] = c
+	}
+	_, err := mgm.Coll(&datagovin.Change{}).InsertMany(mgm.Ctx(), docs)
+	if err != nil {
+		return fmt.Errorf("could not save changes: %s", err)
+	}
+	return nil
+}
+
+// revisionAttempts bounds the retries of SaveRevision when another fetch
+// numbers a revision of the same dataset at the same time
+const revisionAttempts = 5
+
+// ensureRevisionIndex makes version numbers unique per dataset, a concurrent
+// fetch numbering the same version then fails instead of adding a duplicate.
+// InitializeDB creates it once per database
+func ensureRevisionIndex() error {
+	_, err := mgm.Coll(&datagovin.DatasetRevision{}).Indexes().CreateOne(mgm.Ctx(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "d_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create revision index: %s", err)
+	}
+	return nil
+}
+
+// SaveRevision keeps the current state of the dataset as a new revision
+// unless it matches the latest one
+func SaveRevision(d *datagovin.Dataset) error {
+	revision, err := datagovin.NewRevision(d)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		latest := &datagovin.DatasetRevision{}
+		opts := options.FindOne().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"data": 0})
+		err = mgm.Coll(latest).First(bson.M{"d_id": d.DID}, latest, opts)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			revision.Version = 1
+		case err != nil:
+			return fmt.Errorf("could not fetch revisions: %s", err)
+		case latest.Hash == revision.Hash && latest.Title == revision.Title && latest.LastModified.Equal(revision.LastModified):
+			return nil
+		default:
+			revision.Version = latest.Version + 1
+		}
+		err = mgm.Coll(revision).Create(revision)
+		if err == nil {
+			return nil
+		}
+		// Another fetch saved this version first, number the revision again
+		if !isDuplicateKey(err) || attempt == revisionAttempts {
+			return fmt.Errorf("could not save revision: %s", err)
+		}
+	}
+}
+
+// ArchiveDatasets keeps a revision of the saved datasets that have none yet,
+// so that fetching a newer version does not lose the data saved before
+// revisions were kept
+func ArchiveDatasets(existing []*datagovin.Dataset) error {
+	if len(existing) == 0 {
+		return nil
+	}
+	ids, err := mgm.Coll(&datagovin.DatasetRevision{}).Distinct(mgm.Ctx(), "d_id", bson.M{"cat_id": existing[0].CatID})
+	if err != nil {
+		return fmt.Errorf("could not fetch revisions: %s", err)
+	}
+	archived := make(map[uint64]bool)
+	for _, id := range ids {
+		switch n := id.(type) {
+		case int64:
+			archived[uint64(n)] = true
+		case int32:
+			archived[uint64(n)] = true
+		}
+	}
+	for _, d := range existing {
+		if archived[d.DID] || d.Data.Entries == nil {
+			continue
+		}
+		if err := SaveRevision(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package datagovin
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+// DuplicateGroup is a set of datasets with the same contents. Canonical is
+// the one created first, the others are its duplicates
+type DuplicateGroup struct {
+	Hash       string
+	Canonical  *datagovin.Dataset
+	Duplicates []*datagovin.Dataset
+}
+
+// FindDuplicates groups the datasets by content hash, computing the hash of
+// the ones fetched before it was stored. Datasets without entries are left out
+// as every empty table has the same hash
+func FindDuplicates(datasets []*datagovin.Dataset) ([]*DuplicateGroup, error) {
+	byHash := make(map[string][]*datagovin.Dataset)
+	for _, d := range datasets {
+		if d.Hash == "" {
+			if len(d.Data.Entries) == 0 {
+				continue
+			}
+			hash, err := d.Data.Hash()
+			if err != nil {
+				return nil, err
+			}
+			d.Hash = hash
+		} else if d.DuplicateOf == 0 && len(d.Data.Entries) == 0 {
+			continue
+		}
+		byHash[d.Hash] = append(byHash[d.Hash], d)
+	}
+	groups := make([]*DuplicateGroup, 0)
+	for hash, ds := range byHash {
+		if len(ds) < 2 {
+			continue
+		}
+		// Merged datasets hold no data, the canonical one is never merged
+		sort.Slice(ds, func(i, j int) bool {
+			if (ds[i].DuplicateOf == 0) != (ds[j].DuplicateOf == 0) {
+				return ds[i].DuplicateOf == 0
+			}
+			if !ds[i].Created.Equal(ds[j].Created) {
+				return ds[i].Created.Before(ds[j].Created)
+			}
+			return ds[i].DID < ds[j].DID
+		})
+		groups = append(groups, &DuplicateGroup{
+			Hash:       hash,
+			Canonical:  ds[0],
+			Duplicates: ds[1:],
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Canonical.DID < groups[j].Canonical.DID
+	})
+	return groups, nil
+}
+
+// sameData tells whether the data holds exactly the same fields and values.
+// Hashes normalise values, so that "007" and "7" hash alike, which is fine to
+// report duplicates but not to drop data
+func sameData(a, b datagovin.Data) bool {
+	return reflect.DeepEqual(a.Fields, b.Fields) && reflect.DeepEqual(a.Entries, b.Entries)
+}
+
+// MergeDuplicates points the duplicates of the group at the canonical dataset
+// and drops their data. Only exact copies of the canonical data are merged,
+// the duplicates whose values differ are returned along with the number of
+// datasets merged
+func MergeDuplicates(g *DuplicateGroup) (int, []*datagovin.Dataset, error) {
+	merged := 0
+	differing := make([]*datagovin.Dataset, 0)
+	for _, d := range g.Duplicates {
+		if d.DuplicateOf == g.Canonical.DID {
+			continue
+		}
+		if d.DuplicateOf == 0 && !sameData(d.Data, g.Canonical.Data) {
+			differing = append(differing, d)
+			continue
+		}
+		d.DuplicateOf = g.Canonical.DID
+		d.Data = datagovin.Data{}
+		if err := SaveDataset(d); err != nil {
+			return merged, differing, err
+		}
+		merged++
+	}
+	if g.Canonical.ID.IsZero() || g.Canonical.DuplicateOf != 0 {
+		return merged, differing, nil
+	}
+	// Stores the hash if it was computed for the report
+	return merged, differing, SaveDataset(g.Canonical)
+}
+
+// Dedupe reports the saved datasets of the profile that have the same
+// contents, and with merge set keeps the data of only one of them
+func Dedupe(p *Profile, merge bool) error {
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %s", err)
+	}
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	catalogs, err := GetAllCatalog()
+	if err != nil {
+		return err
+	}
+	datasets := make([]*datagovin.Dataset, 0)
+	for _, c := range FilterCatalogs(SourceCatalogs(catalogs, p.Source), filter) {
+		ds, err := GetCatalogInfo(c)
+		if err != nil {
+			return err
+		}
+		datasets = append(datasets, ds...)
+	}
+	groups, err := FindDuplicates(datasets)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tKEPT\tDUPLICATES\tTITLE")
+	duplicates := 0
+	for _, g := range groups {
+		ids := make([]string, len(g.Duplicates))
+		for i, d := range g.Duplicates {
+			ids[i] = strconv.FormatUint(d.DID, 10)
+		}
+		duplicates = duplicates + len(g.Duplicates)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", g.Hash[:12], g.Canonical.DID, strings.Join(ids, ","), g.Canonical.Title)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Found %d duplicates of %d datasets\n", duplicates, len(groups))
+	if !merge {
+		return nil
+	}
+
+	merged := 0
+	differing := make([]string, 0)
+	for _, g := range groups {
+		n, ds, err := MergeDuplicates(g)
+		merged = merged + n
+		for _, d := range ds {
+			differing = append(differing, fmt.Sprintf("%d (of %d)", d.DID, g.Canonical.DID))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to merge duplicates of %d: %s", g.Canonical.DID, err)
+		}
+	}
+	fmt.Printf("Merged %d datasets\n", merged)
+	if merged != 0 && p.Summarise {
+		fmt.Println("Run summary to remove the summarised tables of the merged datasets")
+	}
+	if len(differing) != 0 {
+		fmt.Printf("Kept %d datasets whose values only match once normalised: %s\n", len(differing), strings.Join(differing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,437 @@
+package datagovin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+// Filter selects catalogs. Filters are parsed from expressions such as
+//
+//	title~"Census" && department="Ministry of Home Affairs"
+//
+// Comparisons take a field, an operator and a quoted string or a number.
+// The fields are title, department, cat_id, created, last_modified and
+// other.<key> for the remaining fields returned by data.gov.in. The
+// operators are = and != for equality, ~ and !~ for case insensitive regular
+// expression matches and <, <=, > and >= for ordering. Dates are compared
+// against values like "2016-01-02" or RFC 3339 timestamps. A comparison on
+// department holds when it holds for any department. Comparisons combine with
+// &&, || and ! and group with parentheses
+type Filter interface {
+	Match(c *datagovin.Catalog) bool
+}
+
+// ParseFilter parses a filter expression, the empty expression matches every
+// catalog
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return matchAll{}, nil
+	}
+	p := &parser{tokens: tokens, end: len([]rune(expr))}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %s at %d", p.peek().text, p.peek().pos)
+	}
+	return f, nil
+}
+
+// FilterCatalogs returns the catalogs matching f
+func FilterCatalogs(cats []*datagovin.Catalog, f Filter) []*datagovin.Catalog {
+	result := make([]*datagovin.Catalog, 0)
+	for _, c := range cats {
+		if f.Match(c) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+type tokenKind int
+
+const (
+	tokField tokenKind = iota
+	tokString
+	tokNumber
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+func isFieldRune(r rune) bool {
+	return r == '_' || r == '.' || r == ':' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		rest := string(runes[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.HasPrefix(rest, "&&"):
+			tokens = append(tokens, token{tokAnd, "&&", i})
+			i += 2
+		case strings.HasPrefix(rest, "||"):
+			tokens = append(tokens, token{tokOr, "||", i})
+			i += 2
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %s", i, err)
+			}
+			tokens = append(tokens, token{tokString, value, i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[i:j]), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && isFieldRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokField, string(runes[i:j]), i})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(rest, op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				if r == '!' {
+					tokens = append(tokens, token{tokNot, "!", i})
+					i++
+					continue
+				}
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// end is the position reported when the filter ends too early
+	end int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+	if p.done() {
+		return token{}, fmt.Errorf("unexpected end of filter at %d", p.end)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && p.peek().kind == tokOr {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && p.peek().kind == tokAnd {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Filter, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tokNot:
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f}, nil
+	case tokLParen:
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at %d", closing.pos)
+		}
+		return f, nil
+	case tokField:
+		return p.comparison(t)
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+}
+
+func (p *parser) comparison(field token) (Filter, error) {
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("expected operator at %d", op.pos)
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if value.kind != tokString && value.kind != tokNumber {
+		return nil, fmt.Errorf("expected value at %d", value.pos)
+	}
+	return newComparison(field, op.text, value.text)
+}
+
+type matchAll struct{}
+
+func (matchAll) Match(*datagovin.Catalog) bool { return true }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(c *datagovin.Catalog) bool { return f.left.Match(c) && f.right.Match(c) }
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(c *datagovin.Catalog) bool { return f.left.Match(c) || f.right.Match(c) }
+
+type notFilter struct{ f Filter }
+
+func (f notFilter) Match(c *datagovin.Catalog) bool { return !f.f.Match(c) }
+
+// comparison compares every value of a catalog field with a constant and
+// holds if any of them satisfies it
+type comparison struct {
+	values func(c *datagovin.Catalog) []interface{}
+	op     string
+	text   string
+	number *float64
+	date   *time.Time
+	regexp *regexp.Regexp
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func newComparison(field token, op, value string) (Filter, error) {
+	c := &comparison{op: op, text: value}
+	isDate := false
+	switch field.text {
+	case "title":
+		c.values = func(cat *datagovin.Catalog) []interface{} { return []interface{}{cat.Title} }
+	case "department":
+		c.values = func(cat *datagovin.Catalog) []interface{} {
+			values := make([]interface{}, len(cat.Departments))
+			for i, d := range cat.Departments {
+				values[i] = d
+			}
+			return values
+		}
+	case "cat_id":
+		c.values = func(cat *datagovin.Catalog) []interface{} { return []interface{}{cat.CatID} }
+	case "created":
+		isDate = true
+		c.values = func(cat *datagovin.Catalog) []interface{} { return []interface{}{cat.Created} }
+	case "last_modified":
+		isDate = true
+		c.values = func(cat *datagovin.Catalog) []interface{} { return []interface{}{cat.LastModified} }
+	default:
+		if !strings.HasPrefix(field.text, "other.") {
+			return nil, fmt.Errorf("unknown field %s at %d", field.text, field.pos)
+		}
+		key := strings.TrimPrefix(field.text, "other.")
+		c.values = func(cat *datagovin.Catalog) []interface{} {
+			v, ok := cat.Other[key]
+			if !ok {
+				return nil
+			}
+			if list, ok := v.([]interface{}); ok {
+				return list
+			}
+			return []interface{}{v}
+		}
+	}
+
+	switch {
+	case op == "~" || op == "!~":
+		re, err := regexp.Compile("(?i)" + value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at %d: %s", field.pos, err)
+		}
+		c.regexp = re
+	case isDate:
+		t, err := parseDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s at %d", err, field.pos)
+		}
+		c.date = &t
+	default:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			c.number = &n
+		}
+	}
+	if op == "!=" || op == "!~" {
+		// Negated operators hold when no value satisfies the positive one
+		positive := *c
+		positive.op = op[1:]
+		if op == "!=" {
+			positive.op = "="
+		}
+		return notFilter{&positive}, nil
+	}
+	return c, nil
+}
+
+func (c *comparison) Match(cat *datagovin.Catalog) bool {
+	for _, v := range c.values(cat) {
+		if c.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *comparison) matchValue(v interface{}) bool {
+	if c.regexp != nil {
+		return c.regexp.MatchString(fmt.Sprint(v))
+	}
+	var cmp int
+	switch {
+	case c.date != nil:
+		t, ok := v.(time.Time)
+		if !ok {
+			return false
+		}
+		cmp = compareDates(t, *c.date)
+	case c.number != nil:
+		n, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+		if err != nil {
+			return false
+		}
+		cmp = compareNumbers(n, *c.number)
+	default:
+		cmp = strings.Compare(fmt.Sprint(v), c.text)
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareDates(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
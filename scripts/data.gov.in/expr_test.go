@@ -0,0 +1,150 @@
+package datagovin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+func date(s string) time.Time {
+	t, err := parseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var filterCatalogs = []*datagovin.Catalog{
+	{
+		Title:        "Crime in India 2016",
+		CatID:        1,
+		Departments:  []string{"Ministry of Home Affairs", "National Crime Records Bureau"},
+		Created:      date("2016-05-01"),
+		LastModified: date("2021-03-01T10:00:00Z"),
+		Other:        map[string]interface{}{"sector": []interface{}{"Crime", "Law"}, "views": float64(120), "state": "All India"},
+	},
+	{
+		Title:        "Census 2011 Population",
+		CatID:        2,
+		Departments:  []string{"Ministry of Home Affairs"},
+		Created:      date("2011-01-01"),
+		LastModified: date("2019-06-01"),
+		Other:        map[string]interface{}{"sector": "Census", "views": "45"},
+	},
+	{
+		Title:        "Rainfall Statistics",
+		CatID:        3,
+		Departments:  []string{"India Meteorological Department"},
+		Created:      date("2018-07-15"),
+		LastModified: date("2018-07-15"),
+		Other:        map[string]interface{}{},
+	},
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []uint64
+	}{
+		{``, []uint64{1, 2, 3}},
+
+		// && binds tighter than ||, ! tighter than both
+		{`title~"crime" || title~"census" && cat_id=3`, []uint64{1}},
+		{`(title~"crime" || title~"census") && cat_id=2`, []uint64{2}},
+		{`cat_id=1 || cat_id=2 && cat_id=3 || cat_id=3`, []uint64{1, 3}},
+		{`!title~"crime" && !title~"census"`, []uint64{3}},
+		{`!(title~"crime" || cat_id=3)`, []uint64{2}},
+		{`!!cat_id=1`, []uint64{1}},
+		{`((cat_id=1))`, []uint64{1}},
+
+		// ~ is a case insensitive regular expression, = is exact
+		{`title~"CRIME"`, []uint64{1}},
+		{`title~"^c.*\\d+"`, []uint64{1, 2}},
+		{`title="Crime in India 2016"`, []uint64{1}},
+		{`title="crime in india 2016"`, []uint64{}},
+		{`title!="Rainfall Statistics"`, []uint64{1, 2}},
+		{`title!~"statistics"`, []uint64{1, 2}},
+		{`title<"D"`, []uint64{1, 2}},
+
+		// Numbers compare as numbers
+		{`cat_id>=2`, []uint64{2, 3}},
+		{`cat_id<2`, []uint64{1}},
+		{`cat_id=2.0`, []uint64{2}},
+
+		// Dates
+		{`created<"2016-01-01"`, []uint64{2}},
+		{`created>="2016-05-01"`, []uint64{1, 3}},
+		{`created!="2011-01-01"`, []uint64{1, 3}},
+		{`last_modified="2018-07-15"`, []uint64{3}},
+		{`last_modified>"2021-03-01T09:00:00Z"`, []uint64{1}},
+		{`last_modified<="2019-06-01T00:00:00Z"`, []uint64{2, 3}},
+		{`last_modified>"2021-03-01T15:00:00+05:30"`, []uint64{1}},
+		{`created~"^2016-"`, []uint64{1}},
+		{`last_modified!~"2018"`, []uint64{1, 2}},
+
+		// Any department satisfying the comparison is enough
+		{`department="Ministry of Home Affairs"`, []uint64{1, 2}},
+		{`department="National Crime Records Bureau"`, []uint64{1}},
+		{`department!="Ministry of Home Affairs"`, []uint64{3}},
+		{`department~"meteorolog"`, []uint64{3}},
+		{`department!~"ministry"`, []uint64{3}},
+
+		// Other fields, lists hold when any element does
+		{`other.sector="Law"`, []uint64{1}},
+		{`other.sector~"^c"`, []uint64{1, 2}},
+		{`other.views>100`, []uint64{1}},
+		{`other.views<100`, []uint64{2}},
+		{`other.state="All India"`, []uint64{1}},
+		{`other.missing="x"`, []uint64{}},
+		{`other.missing!="x"`, []uint64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint64, 0)
+			for _, c := range FilterCatalogs(filterCatalogs, f) {
+				got = append(got, c.CatID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`title="crime`, `unterminated string at 6`},
+		{`cat_id=1 && title~"crime\"`, `unterminated string at 18`},
+		{`title~"crime" &&`, `unexpected end of filter at 16`},
+		{`title=`, `unexpected end of filter at 6`},
+		{`(cat_id=1`, `unexpected end of filter at 9`},
+		{`title~"a" || && cat_id=1`, `unexpected && at 13`},
+		{`cat_id=1)`, `unexpected ) at 8`},
+		{`title "x"`, `expected operator at 6`},
+		{`title = && cat_id=1`, `expected value at 8`},
+		{`title # "x"`, `unexpected '#' at 6`},
+		{`author="x"`, `unknown field author at 0`},
+		{`cat_id=1 && publisher~"x"`, `unknown field publisher at 12`},
+		{`created<"yesterday"`, `invalid date "yesterday" at 0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr)
+			if err == nil {
+				t.Fatal("parsed")
+			}
+			if err.Error() != tt.want {
+				t.Fatalf("got %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package datagovin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/util"
+)
+
+// Stages of fetching a catalog or dataset, used to report where it failed
+const (
+	StageSaveCatalog   = "save_catalog"
+	StageLoadSaved     = "load_saved"
+	StageListDatasets  = "list_datasets"
+	StageRecordChanges = "record_changes"
+	StageFetchData     = "fetch_data"
+	StageSaveDataset   = "save_dataset"
+	StageSaveRevision  = "save_revision"
+	StageJournal       = "journal"
+)
+
+// stageError tags an error with the stage that failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func staged(stage string, err error) error {
+	return &stageError{stage: stage, err: err}
+}
+
+func (e *stageError) Error() string {
+	return e.stage + ": " + e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// Failure is a catalog or dataset that could not be fetched. It keeps the
+// catalog or dataset so that it can be retried from the report alone
+type Failure struct {
+	Kind   datagovin.ItemKind `json:"kind"`
+	ItemID uint64             `json:"item_id"`
+	CatID  uint64             `json:"cat_id"`
+	Title  string             `json:"title"`
+	Stage  string             `json:"stage"`
+	// Status is the HTTP status of the failed request, if any
+	Status  int                `json:"status,omitempty"`
+	Error   string             `json:"error"`
+	At      time.Time          `json:"at"`
+	Catalog *datagovin.Catalog `json:"catalog,omitempty"`
+	Dataset *datagovin.Dataset `json:"dataset,omitempty"`
+}
+
+func newFailure(err error) *Failure {
+	f := &Failure{
+		Error: err.Error(),
+		At:    time.Now(),
+	}
+	var se *stageError
+	if errors.As(err, &se) {
+		f.Stage = se.stage
+		f.Error = se.err.Error()
+	}
+	var stepErr *util.StepError
+	var statusErr *StatusError
+	if errors.As(err, &stepErr) {
+		f.Status = stepErr.StatusCode
+	} else if errors.As(err, &statusErr) {
+		f.Status = statusErr.StatusCode
+	}
+	return f
+}
+
+func catalogFailure(c *datagovin.Catalog, err error) *Failure {
+	f := newFailure(err)
+	f.Kind = datagovin.ItemCatalog
+	f.ItemID = c.CatID
+	f.CatID = c.CatID
+	f.Title = c.Title
+	f.Catalog = c
+	return f
+}
+
+func datasetFailure(d *datagovin.Dataset, err error) *Failure {
+	f := newFailure(err)
+	f.Kind = datagovin.ItemDataset
+	f.ItemID = d.DID
+	f.CatID = d.CatID
+	f.Title = d.Title
+	dataset := *d
+	dataset.Data = datagovin.Data{}
+	f.Dataset = &dataset
+	return f
+}
+
+// PrintFailures writes the failures as a table
+func PrintFailures(out io.Writer, failures []*Failure) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tID\tCATALOG\tSTAGE\tSTATUS\tERROR")
+	for _, f := range failures {
+		status := "-"
+		if f.Status != 0 {
+			status = fmt.Sprint(f.Status)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", f.Kind, f.ItemID, f.CatID, f.Stage, status, f.Error)
+	}
+	return w.Flush()
+}
+
+// WriteFailures writes the failures to path as JSONL, replacing the report of
+// an earlier run
+func WriteFailures(path string, failures []*Failure) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create report: %s", err)
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, f := range failures {
+		if err := encoder.Encode(f); err != nil {
+			file.Close()
+			return fmt.Errorf("could not write report: %s", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write report: %s", err)
+	}
+	return file.Close()
+}
+
+// ReadFailures reads a report written by WriteFailures
+func ReadFailures(path string) ([]*Failure, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open report: %s", err)
+	}
+	defer file.Close()
+	failures := make([]*Failure, 0)
+	decoder := json.NewDecoder(file)
+	for {
+		f := new(Failure)
+		err := decoder.Decode(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse report: %s", err)
+		}
+		failures = append(failures, f)
+	}
+	return failures, nil
+}
+
+// FailedItems returns the catalogs and datasets of the failures
+func FailedItems(failures []*Failure) ([]*datagovin.Catalog, []*datagovin.Dataset) {
+	catalogs := make([]*datagovin.Catalog, 0)
+	datasets := make([]*datagovin.Dataset, 0)
+	for _, f := range failures {
+		switch {
+		case f.Kind == datagovin.ItemCatalog && f.Catalog != nil:
+			catalogs = append(catalogs, f.Catalog)
+		case f.Kind == datagovin.ItemDataset && f.Dataset != nil:
+			datasets = append(datasets, f.Dataset)
+		}
+	}
+	return catalogs, datasets
+}
@@ -0,0 +1,141 @@
+package datagovin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/util"
+)
+
+func TestNewFailure(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name   string
+		err    error
+		stage  string
+		status int
+		error  string
+	}{
+		{"untagged", failed, "", 0, "failed"},
+		{"staged", staged(StageSaveCatalog, failed), StageSaveCatalog, 0, "failed"},
+		{"request group", staged(StageFetchData, &util.StepError{Step: 2, StatusCode: 503, Err: failed}), StageFetchData, 503, "step 2 (status 503): failed"},
+		{"listing", staged(StageListDatasets, &StatusError{StatusCode: 429, Status: "429 Too Many Requests"}), StageListDatasets, 429, "unexpected response: 429 Too Many Requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFailure(tt.err)
+			if f.Stage != tt.stage || f.Status != tt.status || f.Error != tt.error {
+				t.Fatalf("got stage %q, status %d, error %q", f.Stage, f.Status, f.Error)
+			}
+		})
+	}
+}
+
+func reportPath(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "failures.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func TestFailureReportRoundTrip(t *testing.T) {
+	path, cleanup := reportPath(t)
+	defer cleanup()
+	catalog := &datagovin.Catalog{
+		CatID:        1,
+		Title:        "Crime in India 2016",
+		Departments:  []string{"Ministry of Home Affairs"},
+		Created:      changed,
+		LastModified: changed,
+		Other:        map[string]interface{}{"sector": "Crime"},
+		Source:       "data.gov.in",
+	}
+	dataset := &datagovin.Dataset{
+		DID:          101,
+		CatID:        1,
+		Title:        "Table 1",
+		Created:      changed,
+		LastModified: changed,
+		Data:         datagovin.Data{Entries: [][]interface{}{{"Kerala", float64(1)}}},
+	}
+	failures := []*Failure{
+		catalogFailure(catalog, staged(StageListDatasets, &StatusError{StatusCode: 500, Status: "500 Internal Server Error"})),
+		datasetFailure(dataset, staged(StageFetchData, &util.StepError{Step: 1, StatusCode: 200, Err: ErrNoToken})),
+	}
+	for _, f := range failures {
+		f.At = changed
+	}
+	// A report replaces the one of an earlier run
+	if err := WriteFailures(path, append(failures, failures...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFailures(path, failures); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFailures(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, failures) {
+		t.Fatalf("read %+v, want %+v", read, failures)
+	}
+	// The report keeps the dataset without its data
+	if len(read[1].Dataset.Data.Entries) != 0 || len(dataset.Data.Entries) != 1 {
+		t.Fatal("report holds the data of the dataset")
+	}
+
+	if err := WriteFailures(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ReadFailures(path); err != nil || len(read) != 0 {
+		t.Fatalf("empty report read as %v, %v", read, err)
+	}
+}
+
+func TestReadFailuresInvalid(t *testing.T) {
+	path, cleanup := reportPath(t)
+	defer cleanup()
+	if _, err := ReadFailures(path); err == nil {
+		t.Fatal("missing report read")
+	}
+	if err := os.WriteFile(path, []byte("{\"kind\":\"catalog\"}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFailures(path); err == nil {
+		t.Fatal("invalid report read")
+	}
+}
+
+// TestFailedItems selects the items --retry-failed fetches again
+func TestFailedItems(t *testing.T) {
+	catalog := &datagovin.Catalog{CatID: 1}
+	dataset := &datagovin.Dataset{DID: 101, CatID: 2}
+	failures := []*Failure{
+		{Kind: datagovin.ItemCatalog, ItemID: 1, Catalog: catalog},
+		{Kind: datagovin.ItemDataset, ItemID: 101, Dataset: dataset},
+		// Reports written without the item can not be retried
+		{Kind: datagovin.ItemCatalog, ItemID: 3},
+		{Kind: datagovin.ItemDataset, ItemID: 102},
+		// The kind decides which item is retried
+		{Kind: datagovin.ItemDataset, ItemID: 4, Catalog: &datagovin.Catalog{CatID: 4}},
+	}
+	catalogs, datasets := FailedItems(failures)
+	if len(catalogs) != 1 || catalogs[0] != catalog {
+		t.Fatalf("got catalogs %v", catalogIDs(catalogs))
+	}
+	if len(datasets) != 1 || datasets[0] != dataset {
+		t.Fatalf("got datasets %v", datasetIDs(datasets))
+	}
+
+	catalogs, datasets = FailedItems(nil)
+	if len(catalogs) != 0 || len(datasets) != 0 {
+		t.Fatal("items selected from an empty report")
+	}
+}
@@ -0,0 +1,245 @@
+// Package fake serves a small in-memory imitation of the data.gov.in portal
+// for exercising the scraper without a network
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the portal. They mirror the ones of the scraper, which cannot be
+// imported here as the scraper tests depend on this package
+const (
+	catalogPath    = "/cust-api/v1"
+	datasetPath    = catalogPath + "/resource"
+	tokenPath      = "/save_reasons"
+	dataPath       = "/node"
+	dataPathSuffix = "/datastore/export/json"
+)
+
+// Catalog is a catalog served by the portal along with its datasets
+type Catalog struct {
+	ID          uint64
+	Title       string
+	Departments []string
+	Created     time.Time
+	Changed     time.Time
+	Datasets    []*Dataset
+}
+
+// Dataset is a dataset served by the portal and the contents of its export
+type Dataset struct {
+	ID      uint64
+	Title   string
+	Created time.Time
+	Changed time.Time
+	Fields  []map[string]string
+	Data    [][]interface{}
+}
+
+// Portal answers the catalog and dataset listings, the download token form
+// and the json exports the way data.gov.in does
+type Portal struct {
+	Catalogs []*Catalog
+	// PageSize caps the records of a single listing response, like the portal
+	// does, defaults to 10
+	PageSize int
+
+	tokens map[string]uint64
+	issued int
+	lock   *sync.Mutex
+}
+
+func NewPortal(catalogs ...*Catalog) *Portal {
+	return &Portal{
+		Catalogs: catalogs,
+		PageSize: 10,
+		tokens:   make(map[string]uint64),
+		lock:     new(sync.Mutex),
+	}
+}
+
+// NewServer starts serving the portal, the caller must Close the server
+func NewServer(p *Portal) *httptest.Server {
+	return httptest.NewServer(p)
+}
+
+type listing struct {
+	Status  string                   `json:"status"`
+	Records []map[string]interface{} `json:"records"`
+	Total   int                      `json:"total"`
+	Count   int                      `json:"count"`
+}
+
+func (p *Portal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == catalogPath && r.Method == http.MethodGet:
+		p.catalogs(w, r)
+	case path == datasetPath && r.Method == http.MethodGet:
+		p.datasets(w, r)
+	case path == tokenPath && r.Method == http.MethodPost:
+		p.token(w, r)
+	case strings.HasPrefix(path, dataPath+"/") &&
+		strings.HasSuffix(path, dataPathSuffix) &&
+		r.Method == http.MethodGet:
+		id := strings.TrimSuffix(strings.TrimPrefix(path, dataPath+"/"), dataPathSuffix)
+		p.export(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// page answers with the records selected by the offset and limit parameters
+func (p *Portal) page(w http.ResponseWriter, r *http.Request, records []map[string]interface{}) {
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit > p.PageSize {
+		limit = p.PageSize
+	}
+	if offset > len(records) {
+		offset = len(records)
+	}
+	end := offset + limit
+	if end > len(records) {
+		end = len(records)
+	}
+	writeJSON(w, &listing{
+		Status:  "ok",
+		Records: records[offset:end],
+		Total:   len(records),
+		Count:   end - offset,
+	})
+}
+
+func (p *Portal) catalogs(w http.ResponseWriter, r *http.Request) {
+	search := strings.ToLower(r.URL.Query().Get("query"))
+	catalogs := make([]*Catalog, len(p.Catalogs))
+	copy(catalogs, p.Catalogs)
+	if r.URL.Query().Get("sort[changed]") == "desc" {
+		sort.SliceStable(catalogs, func(i, j int) bool {
+			return catalogs[i].Changed.After(catalogs[j].Changed)
+		})
+	}
+	records := make([]map[string]interface{}, 0)
+	for _, c := range catalogs {
+		if search != "" && !strings.Contains(strings.ToLower(c.Title), search) {
+			continue
+		}
+		records = append(records, map[string]interface{}{
+			"id":                             c.ID,
+			"title":                          c.Title,
+			"field_ministry_department:name": c.Departments,
+			"created":                        timestamp(c.Created),
+			"changed":                        timestamp(c.Changed),
+		})
+	}
+	p.page(w, r, records)
+}
+
+func (p *Portal) datasets(w http.ResponseWriter, r *http.Request) {
+	catID, err := strconv.ParseUint(r.URL.Query().Get("filters[field_catalog_reference]"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid catalog reference", http.StatusBadRequest)
+		return
+	}
+	records := make([]map[string]interface{}, 0)
+	for _, c := range p.Catalogs {
+		if c.ID != catID {
+			continue
+		}
+		for _, d := range c.Datasets {
+			records = append(records, map[string]interface{}{
+				"id":      d.ID,
+				"title":   d.Title,
+				"created": timestamp(d.Created),
+				"changed": timestamp(d.Changed),
+			})
+		}
+	}
+	p.page(w, r, records)
+}
+
+func (p *Portal) dataset(id uint64) *Dataset {
+	d, _ := p.find(id)
+	return d
+}
+
+// find returns the dataset and the catalog holding it
+func (p *Portal) find(id uint64) (*Dataset, *Catalog) {
+	for _, c := range p.Catalogs {
+		for _, d := range c.Datasets {
+			if d.ID == id {
+				return d, c
+			}
+		}
+	}
+	return nil, nil
+}
+
+// token issues a download token for the node named in the reasons2 field
+// when the form was submitted from the page of its catalog
+func (p *Portal) token(w http.ResponseWriter, r *http.Request) {
+	var reasons struct {
+		NodeID      string `json:"node_id"`
+		RedirectURL string `json:"redirect_url"`
+	}
+	if err := json.Unmarshal([]byte(r.PostFormValue("reasons2")), &reasons); err != nil {
+		http.Error(w, "invalid reasons", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseUint(reasons.NodeID, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// The portal answers unknown nodes and forms from other pages without a
+	// token
+	d, c := p.find(id)
+	if d == nil || reasons.RedirectURL != dataPath+"/"+strconv.FormatUint(c.ID, 10) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	p.lock.Lock()
+	p.issued++
+	token := "token-" + strconv.Itoa(p.issued)
+	p.tokens[token] = id
+	p.lock.Unlock()
+	w.Write([]byte(token))
+}
+
+func (p *Portal) export(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.lock.Lock()
+	node, ok := p.tokens[r.URL.Query().Get("token")]
+	p.lock.Unlock()
+	if !ok || node != id {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+	d := p.dataset(id)
+	writeJSON(w, map[string]interface{}{
+		"fields": d.Fields,
+		"data":   d.Data,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,21 +1,11 @@
 package datagovin
 
 import (
-	"strings"
+	"time"
 
 	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
 )
 
-func CrimeInIndia(cats []*datagovin.Catalog) []*datagovin.Catalog {
-	result := make([]*datagovin.Catalog, 0)
-	for _, c := range cats {
-		if strings.Contains(c.Title, "Crime in India") {
-			result = append(result, c)
-		}
-	}
-	return result
-}
-
 func CompareCatalogs(list1 []*datagovin.Catalog, list2 []*datagovin.Catalog) []*datagovin.Catalog {
 	result := make([]*datagovin.Catalog, 0)
 	catMap := make(map[uint64]*datagovin.Catalog)
@@ -24,7 +14,8 @@ func CompareCatalogs(list1 []*datagovin.Catalog, list2 []*datagovin.Catalog) []*
 	}
 	for _, c := range list1 {
 		existing, ok := catMap[c.CatID]
-		if ok && c.LastModified.After(existing.LastModified) {
+		if ok && (c.LastModified.After(existing.LastModified) || existing.Removed) {
+			existing.Removed = false
 			existing.LastModified = c.LastModified
 			existing.Title = c.Title
 			existing.Departments = c.Departments
@@ -45,7 +36,8 @@ func CompareDataSets(list1 []*datagovin.Dataset, list2 []*datagovin.Dataset) []*
 	}
 	for _, d := range list1 {
 		existing, ok := datasetMap[d.DID]
-		if ok && d.LastModified.After(existing.LastModified) {
+		if ok && (d.LastModified.After(existing.LastModified) || existing.Removed) {
+			existing.Removed = false
 			existing.Title = d.Title
 			existing.LastModified = d.LastModified
 			existing.Other = d.Other
@@ -56,3 +48,81 @@ func CompareDataSets(list1 []*datagovin.Dataset, list2 []*datagovin.Dataset) []*
 	}
 	return result
 }
+
+// ResolveCatalogs gives the catalogs of list1 the ids of their saved copies in
+// list2 so that saving them updates the copies instead of adding new ones
+func ResolveCatalogs(list1 []*datagovin.Catalog, list2 []*datagovin.Catalog) []*datagovin.Catalog {
+	catMap := make(map[uint64]*datagovin.Catalog)
+	for _, c := range list2 {
+		catMap[c.CatID] = c
+	}
+	for _, c := range list1 {
+		if existing, ok := catMap[c.CatID]; ok {
+			c.ID = existing.ID
+		}
+	}
+	return list1
+}
+
+func retitled(topic string, kind datagovin.ItemKind, id, catID uint64, oldTitle, newTitle string) *datagovin.Change {
+	return &datagovin.Change{
+		Topic:      topic,
+		Kind:       kind,
+		ItemID:     id,
+		CatID:      catID,
+		Change:     datagovin.ChangeRetitled,
+		OldTitle:   oldTitle,
+		NewTitle:   newTitle,
+		DetectedAt: time.Now(),
+	}
+}
+
+func deleted(topic string, kind datagovin.ItemKind, id, catID uint64, title string) *datagovin.Change {
+	return &datagovin.Change{
+		Topic:      topic,
+		Kind:       kind,
+		ItemID:     id,
+		CatID:      catID,
+		Change:     datagovin.ChangeDeleted,
+		OldTitle:   title,
+		DetectedAt: time.Now(),
+	}
+}
+
+// CatalogChanges finds the catalogs of list2 that are retitled in list1. When
+// list1 is a complete listing the catalogs missing from it are deleted
+func CatalogChanges(topic string, list1 []*datagovin.Catalog, list2 []*datagovin.Catalog, complete bool) []*datagovin.Change {
+	changes := make([]*datagovin.Change, 0)
+	catMap := make(map[uint64]*datagovin.Catalog)
+	for _, c := range list1 {
+		catMap[c.CatID] = c
+	}
+	for _, existing := range list2 {
+		c, ok := catMap[existing.CatID]
+		if ok && c.Title != existing.Title {
+			changes = append(changes, retitled(topic, datagovin.ItemCatalog, c.CatID, c.CatID, existing.Title, c.Title))
+		} else if !ok && complete && !existing.Removed {
+			changes = append(changes, deleted(topic, datagovin.ItemCatalog, existing.CatID, existing.CatID, existing.Title))
+		}
+	}
+	return changes
+}
+
+// DatasetChanges finds the datasets of list2 that are retitled in or missing
+// from the complete listing list1
+func DatasetChanges(topic string, list1 []*datagovin.Dataset, list2 []*datagovin.Dataset) []*datagovin.Change {
+	changes := make([]*datagovin.Change, 0)
+	datasetMap := make(map[uint64]*datagovin.Dataset)
+	for _, d := range list1 {
+		datasetMap[d.DID] = d
+	}
+	for _, existing := range list2 {
+		d, ok := datasetMap[existing.DID]
+		if ok && d.Title != existing.Title {
+			changes = append(changes, retitled(topic, datagovin.ItemDataset, d.DID, d.CatID, existing.Title, d.Title))
+		} else if !ok && !existing.Removed {
+			changes = append(changes, deleted(topic, datagovin.ItemDataset, existing.DID, existing.CatID, existing.Title))
+		}
+	}
+	return changes
+}
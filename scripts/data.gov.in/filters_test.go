@@ -0,0 +1,85 @@
+package datagovin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+func TestCompareCatalogs(t *testing.T) {
+	list1 := []*datagovin.Catalog{
+		{CatID: 1, Title: "Unchanged", LastModified: changed},
+		{CatID: 2, Title: "Modified", LastModified: changed.Add(time.Hour), Departments: []string{"Ministry of Home Affairs"}, Other: map[string]interface{}{"sector": "Crime"}},
+		{CatID: 3, Title: "Older listing", LastModified: changed.Add(-time.Hour)},
+		{CatID: 4, Title: "Relisted", LastModified: changed},
+		{CatID: 5, Title: "New", LastModified: changed},
+	}
+	list2 := []*datagovin.Catalog{
+		{CatID: 1, Title: "Unchanged", LastModified: changed},
+		{CatID: 2, Title: "Before", LastModified: changed, Source: "data.gov.in"},
+		{CatID: 3, Title: "Older listing", LastModified: changed},
+		{CatID: 4, Title: "Relisted", LastModified: changed, Removed: true},
+		{CatID: 6, Title: "Not listed", LastModified: changed},
+	}
+	result := CompareCatalogs(list1, list2)
+
+	if got := catalogIDs(result); !reflect.DeepEqual(got, []uint64{2, 4, 5}) {
+		t.Fatalf("got catalogs %v, want [2 4 5]", got)
+	}
+	// Known catalogs are returned as their saved copy updated from the listing
+	if result[0] != list2[1] || result[1] != list2[3] || result[2] != list1[4] {
+		t.Fatal("known catalogs not returned as their saved copy")
+	}
+	modified := result[0]
+	if modified.Title != "Modified" || !modified.LastModified.Equal(changed.Add(time.Hour)) ||
+		!reflect.DeepEqual(modified.Departments, list1[1].Departments) || !reflect.DeepEqual(modified.Other, list1[1].Other) {
+		t.Fatalf("saved catalog not updated: %+v", modified)
+	}
+	if modified.Source != "data.gov.in" {
+		t.Fatal("fields missing from the listing were overwritten")
+	}
+	if result[1].Removed {
+		t.Fatal("relisted catalog still removed")
+	}
+	if list2[2].Title != "Older listing" || !list2[2].LastModified.Equal(changed) {
+		t.Fatal("an older listing updated the saved catalog")
+	}
+}
+
+func TestCompareDataSets(t *testing.T) {
+	list1 := []*datagovin.Dataset{
+		{DID: 101, CatID: 1, Title: "Unchanged", LastModified: changed},
+		{DID: 102, CatID: 1, Title: "Modified", LastModified: changed.Add(time.Hour), Other: map[string]interface{}{"note": "revised"}},
+		{DID: 103, CatID: 1, Title: "Older listing", LastModified: changed.Add(-time.Hour)},
+		{DID: 104, CatID: 1, Title: "Relisted", LastModified: changed},
+		{DID: 105, CatID: 1, Title: "New", LastModified: changed},
+	}
+	data := datagovin.Data{Entries: [][]interface{}{{"Kerala", float64(1)}}}
+	list2 := []*datagovin.Dataset{
+		{DID: 101, CatID: 1, Title: "Unchanged", LastModified: changed},
+		{DID: 102, CatID: 1, Title: "Before", LastModified: changed, Data: data, Hash: "saved", DuplicateOf: 7},
+		{DID: 103, CatID: 1, Title: "Older listing", LastModified: changed},
+		{DID: 104, CatID: 1, Title: "Relisted", LastModified: changed, Removed: true},
+	}
+	result := CompareDataSets(list1, list2)
+
+	if got := datasetIDs(result); !reflect.DeepEqual(got, []uint64{102, 104, 105}) {
+		t.Fatalf("got datasets %v, want [102 104 105]", got)
+	}
+	if result[0] != list2[1] || result[1] != list2[3] || result[2] != list1[4] {
+		t.Fatal("known datasets not returned as their saved copy")
+	}
+	modified := result[0]
+	if modified.Title != "Modified" || !modified.LastModified.Equal(changed.Add(time.Hour)) || !reflect.DeepEqual(modified.Other, list1[1].Other) {
+		t.Fatalf("saved dataset not updated: %+v", modified)
+	}
+	// The data is fetched again later, until then the saved one is kept
+	if !reflect.DeepEqual(modified.Data, data) || modified.Hash != "saved" || modified.DuplicateOf != 7 {
+		t.Fatal("saved data of the dataset was overwritten")
+	}
+	if result[1].Removed {
+		t.Fatal("relisted dataset still removed")
+	}
+}
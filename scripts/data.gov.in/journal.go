@@ -0,0 +1,200 @@
+package datagovin
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kamva/mgm/v3"
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// journal records the state of every catalog and dataset handled by Fetch so
+// that a run that dies halfway can be resumed
+type journal struct {
+	jobs map[string]*datagovin.Job
+	lock *sync.Mutex
+}
+
+func jobKey(kind datagovin.ItemKind, id uint64) string {
+	return fmt.Sprintf("%s/%d", kind, id)
+}
+
+// findJobs reads the jobs matching filter
+func findJobs(filter bson.M) ([]*datagovin.Job, error) {
+	ctx := mgm.Ctx()
+	cur, err := mgm.Coll(&datagovin.Job{}).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch journal: %s", err)
+	}
+	var jobs []*datagovin.Job
+	err = cur.All(ctx, &jobs)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode journal: %s", err)
+	}
+	return jobs, nil
+}
+
+// loadJournal compacts the journal left by earlier runs and reads the jobs
+// that are not done, along with the done catalogs of their datasets
+func loadJournal() (*journal, error) {
+	if err := compactJournal(); err != nil {
+		return nil, err
+	}
+	jobs, err := findJobs(bson.M{"state": bson.M{"$ne": datagovin.JobDone}})
+	if err != nil {
+		return nil, err
+	}
+	catIDs := make([]uint64, 0)
+	for _, job := range jobs {
+		if job.Kind == datagovin.ItemDataset && job.Dataset != nil {
+			catIDs = append(catIDs, job.Dataset.CatID)
+		}
+	}
+	if len(catIDs) != 0 {
+		catalogs, err := findJobs(bson.M{
+			"kind":    datagovin.ItemCatalog,
+			"state":   datagovin.JobDone,
+			"item_id": bson.M{"$in": catIDs},
+		})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, catalogs...)
+	}
+	j := &journal{
+		jobs: make(map[string]*datagovin.Job),
+		lock: new(sync.Mutex),
+	}
+	for _, job := range jobs {
+		j.jobs[jobKey(job.Kind, job.ItemID)] = job
+	}
+	return j, nil
+}
+
+// compactJournal removes the done datasets and the done catalogs none of
+// whose datasets are left to fetch, nothing is left to resume for them
+func compactJournal() error {
+	coll := mgm.Coll(&datagovin.Job{})
+	ctx := mgm.Ctx()
+	_, err := coll.DeleteMany(ctx, bson.M{"kind": datagovin.ItemDataset, "state": datagovin.JobDone})
+	if err != nil {
+		return fmt.Errorf("could not compact journal: %s", err)
+	}
+	catIDs, err := coll.Distinct(ctx, "dataset.cat_id", bson.M{"kind": datagovin.ItemDataset})
+	if err != nil {
+		return fmt.Errorf("could not compact journal: %s", err)
+	}
+	_, err = coll.DeleteMany(ctx, bson.M{
+		"kind":    datagovin.ItemCatalog,
+		"state":   datagovin.JobDone,
+		"item_id": bson.M{"$nin": catIDs},
+	})
+	if err != nil {
+		return fmt.Errorf("could not compact journal: %s", err)
+	}
+	return nil
+}
+
+func (j *journal) record(kind datagovin.ItemKind, id uint64, state datagovin.JobState, reason error, update func(*datagovin.Job)) error {
+	j.lock.Lock()
+	key := jobKey(kind, id)
+	job, ok := j.jobs[key]
+	if !ok {
+		job = &datagovin.Job{
+			Kind:   kind,
+			ItemID: id,
+		}
+		j.jobs[key] = job
+	}
+	job.State = state
+	job.Reason = ""
+	if reason != nil {
+		job.Reason = reason.Error()
+	}
+	if state == datagovin.JobFetching {
+		job.Attempts = job.Attempts + 1
+	}
+	update(job)
+	// Done datasets have nothing left to resume
+	done := kind == datagovin.ItemDataset && state == datagovin.JobDone
+	if done {
+		delete(j.jobs, key)
+	}
+	j.lock.Unlock()
+
+	if done {
+		if job.ID.IsZero() {
+			return nil
+		}
+		return mgm.Coll(job).Delete(job)
+	}
+	if job.ID.IsZero() {
+		return mgm.Coll(job).Create(job)
+	}
+	return mgm.Coll(job).Update(job)
+}
+
+// Catalog records the state of the catalog, reason is the failure if any
+func (j *journal) Catalog(c *datagovin.Catalog, state datagovin.JobState, reason error) error {
+	return j.record(datagovin.ItemCatalog, c.CatID, state, reason, func(job *datagovin.Job) {
+		job.Catalog = c
+	})
+}
+
+// Dataset records the state of the dataset, the data itself is not journaled
+func (j *journal) Dataset(d *datagovin.Dataset, state datagovin.JobState, reason error) error {
+	return j.record(datagovin.ItemDataset, d.DID, state, reason, func(job *datagovin.Job) {
+		dataset := *d
+		dataset.Data = datagovin.Data{}
+		job.Dataset = &dataset
+	})
+}
+
+// Compact removes the jobs left with nothing to resume once a run is over
+func (j *journal) Compact() error {
+	if err := compactJournal(); err != nil {
+		return err
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	pending := make(map[uint64]bool)
+	for _, job := range j.jobs {
+		if job.Kind == datagovin.ItemDataset && job.Dataset != nil {
+			pending[job.Dataset.CatID] = true
+		}
+	}
+	for key, job := range j.jobs {
+		if job.Kind == datagovin.ItemCatalog && job.State == datagovin.JobDone && !pending[job.ItemID] {
+			delete(j.jobs, key)
+		}
+	}
+	return nil
+}
+
+// Unfinished returns the catalogs matching f and their datasets that are not
+// done. Datasets of unfinished catalogs are left out as listing the catalog
+// again queues them
+func (j *journal) Unfinished(f Filter) ([]*datagovin.Catalog, []*datagovin.Dataset) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	catalogs := make([]*datagovin.Catalog, 0)
+	datasets := make([]*datagovin.Dataset, 0)
+	for _, job := range j.jobs {
+		if job.Kind != datagovin.ItemCatalog || job.State == datagovin.JobDone || job.Catalog == nil || !f.Match(job.Catalog) {
+			continue
+		}
+		catalogs = append(catalogs, job.Catalog)
+	}
+	for _, job := range j.jobs {
+		if job.Kind != datagovin.ItemDataset || job.State == datagovin.JobDone || job.Dataset == nil {
+			continue
+		}
+		catJob, ok := j.jobs[jobKey(datagovin.ItemCatalog, job.Dataset.CatID)]
+		if !ok || catJob.State != datagovin.JobDone || catJob.Catalog == nil || !f.Match(catJob.Catalog) {
+			continue
+		}
+		datasets = append(datasets, job.Dataset)
+	}
+	return catalogs, datasets
+}
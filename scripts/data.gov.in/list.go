@@ -0,0 +1,174 @@
+package datagovin
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// ListCatalogs prints the saved catalogs of the profile
+func ListCatalogs(p *Profile) error {
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %s", err)
+	}
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	catalogs, err := GetAllCatalog()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tLAST MODIFIED")
+	for _, c := range FilterCatalogs(SourceCatalogs(catalogs, p.Source), filter) {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", c.CatID, c.Title, formatDate(c.LastModified))
+	}
+	return w.Flush()
+}
+
+// ShowCatalog prints the saved catalog and a count of its datasets
+func ShowCatalog(catID uint64) error {
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	c, err := GetCatalog(catID)
+	if err != nil {
+		return err
+	}
+	datasets, err := GetCatalogInfo(c)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%d\n", c.CatID)
+	fmt.Fprintf(w, "Title:\t%s\n", c.Title)
+	fmt.Fprintf(w, "Departments:\t%s\n", strings.Join(c.Departments, ", "))
+	fmt.Fprintf(w, "Created:\t%s\n", formatDate(c.Created))
+	fmt.Fprintf(w, "Last modified:\t%s\n", formatDate(c.LastModified))
+	fmt.Fprintf(w, "Datasets:\t%d\n", len(datasets))
+	return w.Flush()
+}
+
+// ListDatasets prints the saved datasets of the catalog
+func ListDatasets(catID uint64) error {
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	datasets, err := GetCatalogInfo(&datagovin.Catalog{CatID: catID})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tLAST MODIFIED\tROWS")
+	for _, d := range datasets {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\n", d.DID, d.Title, formatDate(d.LastModified), len(d.Data.Entries))
+	}
+	return w.Flush()
+}
+
+// ListProfiles prints the known profiles
+func ListProfiles(profiles Profiles) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tQUERY\tFILTER\tSCHEDULE\tDESCRIPTION")
+	for _, name := range profiles.Names() {
+		p := profiles[name]
+		source := p.Source
+		if source == "" {
+			source = DefaultSource
+		}
+		schedule := p.Schedule
+		if schedule == "" {
+			schedule = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Name, source, p.Query, p.Filter, schedule, p.Description)
+	}
+	return w.Flush()
+}
+
+// ListRevisions prints the saved versions of the dataset
+func ListRevisions(dID uint64) error {
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	revisions, err := datagovin.Revisions(dID, true)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tLAST MODIFIED\tFETCHED\tHASH\tTITLE")
+	for _, r := range revisions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.Version, formatDate(r.LastModified), formatDate(r.FetchedAt), r.Hash[:12], r.Title)
+	}
+	return w.Flush()
+}
+
+// DiffRevisions prints the differences between two versions of the dataset
+func DiffRevisions(dID uint64, from, to int) error {
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	fromRev, err := datagovin.Revision(dID, from)
+	if err != nil {
+		return err
+	}
+	toRev, err := datagovin.Revision(dID, to)
+	if err != nil {
+		return err
+	}
+	diff := datagovin.Diff(fromRev, toRev)
+	if diff.Identical() {
+		fmt.Println("Revisions are identical")
+		return nil
+	}
+	if diff.OldTitle != diff.NewTitle {
+		fmt.Printf("Title: %q -> %q\n", diff.OldTitle, diff.NewTitle)
+	}
+	for _, f := range diff.AddedFields {
+		fmt.Printf("+ field %s\n", f)
+	}
+	for _, f := range diff.RemovedFields {
+		fmt.Printf("- field %s\n", f)
+	}
+	for _, c := range diff.ChangedCells {
+		fmt.Printf("~ row %d %s: %v -> %v\n", c.Row, c.Field, c.Old, c.New)
+	}
+	for _, r := range diff.AddedRows {
+		fmt.Printf("+ row %v\n", r)
+	}
+	for _, r := range diff.RemovedRows {
+		fmt.Printf("- row %v\n", r)
+	}
+	return nil
+}
+
+// ListSyncRuns prints the latest runs of the sync daemon for the topic, of
+// every topic when it is empty
+func ListSyncRuns(topic string, limit int64) error {
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	runs, err := datagovin.SyncRuns(topic, limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tSTATE\tSTARTED\tDURATION\tCATALOGS\tDATASETS\tFAILED\tERROR")
+	for _, r := range runs {
+		duration := "-"
+		if !r.FinishedAt.IsZero() {
+			duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", r.Topic, r.State, r.StartedAt.Format(time.RFC3339),
+			duration, r.Catalogs, r.Datasets, r.FailedCatalogs+r.FailedDatasets, r.Error)
+	}
+	return w.Flush()
+}
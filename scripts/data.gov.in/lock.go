@@ -0,0 +1,115 @@
+package datagovin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLockLost is returned when the lock expired and was taken over while it
+// was held
+var ErrLockLost = errors.New("sync lock lost")
+
+// duplicateKey is the code of MongoDB for unique index violations
+const duplicateKey = 11000
+
+func isDuplicateKey(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == duplicateKey
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == duplicateKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lockOwner names this process in the locks it holds
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// ensureLockIndex makes lock names unique, the upsert of a held lock then
+// fails instead of adding a second lock. InitializeDB creates it
+func ensureLockIndex() error {
+	_, err := mgm.Coll(&datagovin.SyncLock{}).Indexes().CreateOne(mgm.Ctx(), mongo.IndexModel{
+		Keys:    bson.M{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create lock index: %s", err)
+	}
+	return nil
+}
+
+// AcquireLock takes the named lock for ttl unless another owner holds it. It
+// returns false when the lock is held
+func AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"name": name,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"owner":       owner,
+			"acquired_at": now,
+			"expires_at":  now.Add(ttl),
+			"updated_at":  now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true)
+	err := mgm.Coll(&datagovin.SyncLock{}).FindOneAndUpdate(mgm.Ctx(), filter, update, opts).Err()
+	if err == nil || err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("could not acquire lock: %s", err)
+}
+
+// RenewLock extends the named lock held by owner by ttl
+func RenewLock(name, owner string, ttl time.Duration) error {
+	now := time.Now()
+	res, err := mgm.Coll(&datagovin.SyncLock{}).UpdateOne(mgm.Ctx(),
+		bson.M{"name": name, "owner": owner},
+		bson.M{"$set": bson.M{"expires_at": now.Add(ttl), "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("could not renew lock: %s", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// ReleaseLock frees the named lock if owner holds it
+func ReleaseLock(name, owner string) error {
+	_, err := mgm.Coll(&datagovin.SyncLock{}).DeleteOne(mgm.Ctx(), bson.M{"name": name, "owner": owner})
+	if err != nil {
+		return fmt.Errorf("could not release lock: %s", err)
+	}
+	return nil
+}
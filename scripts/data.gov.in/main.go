@@ -2,22 +2,40 @@ package datagovin
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/gosuri/uilive"
 	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/source"
 	"github.com/zeu5/visualizations/util"
 )
 
 var (
-	dbURL    string
-	dumpPath string
+	dbURL       string
+	dumpPath    string
+	fetchConfig source.Config
+	fetchResume bool
+	// fetchIncremental only lists the catalogs changed since the last sync
+	fetchIncremental bool
+	// fetchReport is the JSONL file failures are written to and read back
+	// from by fetchRetryFailed
+	fetchReport      string
+	fetchRetryFailed bool
+	// fetchDryRun only plans the fetch, fetchPlan is the JSON file the plan
+	// is written to instead of being printed
+	fetchDryRun bool
+	fetchPlan   string
+	// concurrency bounds the catalogs or datasets worked on at once
+	concurrency int
 )
 
 type datasetColl struct {
@@ -62,78 +80,228 @@ type progress struct {
 	failedCat   int
 	totDat      int
 	failedDat   int
+	failures    []*Failure
 
 	mtx *sync.Mutex
 }
 
-func (p *progress) AddFailedCat() {
+func newProgress() *progress {
+	return &progress{
+		failures: make([]*Failure, 0),
+		mtx:      new(sync.Mutex),
+	}
+}
+
+func (p *progress) AddFailedCat(c *datagovin.Catalog, err error) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
 	p.failedCat = p.failedCat + 1
+	p.failures = append(p.failures, catalogFailure(c, err))
 }
 
-func (p *progress) AddFailedDat() {
+func (p *progress) AddFailedDat(d *datagovin.Dataset, err error) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 	p.failedDat = p.failedDat + 1
+	p.failures = append(p.failures, datasetFailure(d, err))
 }
 
-func Fetch() {
-	fmt.Println("Initializing...")
-	writer := uilive.New()
-	requests := newRequests()
+// interruptContext returns a context that is cancelled on the first interrupt
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		select {
+		case <-sigCh:
+			fmt.Println("Interrupted, cancelling pending requests...")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+	return ctx, cancel
+}
 
-	prog := &progress{
-		mtx: new(sync.Mutex),
+// resumeDatasets leaves out the journaled datasets that were saved before the
+// journal could mark them done
+func resumeDatasets(datasets []*datagovin.Dataset) ([]*datagovin.Dataset, error) {
+	byCatalog := make(map[uint64][]*datagovin.Dataset)
+	for _, d := range datasets {
+		byCatalog[d.CatID] = append(byCatalog[d.CatID], d)
 	}
-	writer.Start()
-	requests.Start()
+	result := make([]*datagovin.Dataset, 0)
+	for catID, ds := range byCatalog {
+		existing, err := GetCatalogInfo(&datagovin.Catalog{CatID: catID})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, CompareDataSets(ds, existing)...)
+	}
+	return result, nil
+}
 
-	err := InitializeDB(dbURL)
+// Fetch mirrors the catalogs of the profile
+func Fetch(p *Profile) {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	if _, err := fetch(ctx, p); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// listCatalogs lists the catalogs of the profile matching filter. With
+// --incremental only the catalogs changed since the high-water mark of the
+// profile are listed, complete tells whether the listing holds every catalog
+func listCatalogs(ctx context.Context, src source.Source, p *Profile, filter Filter) ([]*datagovin.Catalog, *datagovin.SyncState, bool, error) {
+	syncState, err := GetSyncState(p.Name)
 	if err != nil {
-		log.Fatalf("Could not initialize to database: %s\n", err)
+		return nil, nil, false, fmt.Errorf("failed to fetch data from database: %s", err)
+	}
+	// Without a high-water mark the incremental listing is complete as well
+	complete := !fetchIncremental || syncState.HighWater.IsZero()
+	var catalogs []*datagovin.Catalog
+	if complete {
+		catalogs, err = src.ListCatalogs(ctx, p.Query)
+	} else {
+		fmt.Printf("Fetching catalogs changed since %s\n", syncState.HighWater.Format(time.RFC3339))
+		catalogs, err = src.ListCatalogsChangedSince(ctx, p.Query, syncState.HighWater)
 	}
-	existingCatalogs, err := GetAllCatalog()
 	if err != nil {
-		log.Fatalf("Failed to fetch data from database: %s", err)
+		return nil, nil, false, fmt.Errorf("failed to request Catalog info from %s: %s", src.Provenance().Name, err)
+	}
+	for _, c := range catalogs {
+		c.Source = src.Provenance().Name
 	}
+	return FilterCatalogs(catalogs, filter), syncState, complete, nil
+}
 
-	catalogs, err := requests.FetchCatalogs()
+// fetch mirrors the catalogs of the profile until ctx is done. Catalogs and
+// datasets that fail are counted in the progress, the error is for the run
+// as a whole
+func fetch(ctx context.Context, p *Profile) (*progress, error) {
+	fmt.Println("Initializing...")
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %s", err)
+	}
+	if fetchRetryFailed && fetchReport == "" {
+		return nil, errors.New("--retry-failed reads the failures from the report, set it with --report")
+	}
+	if fetchDryRun && (fetchResume || fetchRetryFailed) {
+		return nil, errors.New("a dry run plans a listing, it can not be combined with --resume or --retry-failed")
+	}
+	src, err := newSource(p.Source, fetchConfig)
 	if err != nil {
-		log.Fatalf("Failed to request Catalog info from data.gov.in: %s\n", err.Error())
+		return nil, fmt.Errorf("could not create client: %s", err)
 	}
-	// Fetching only crime in india for now. Too many otherwise
-	catalogs = CrimeInIndia(catalogs)
+	provenance := src.Provenance()
 
-	newCatalgos := CompareCatalogs(catalogs, existingCatalogs)
-	newCatLen := len(newCatalgos)
+	prog := newProgress()
+	src.Start()
+	defer src.Stop()
+
+	err = InitializeDB(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize to database: %s", err)
+	}
+	existingCatalogs, err := GetAllCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data from database: %s", err)
+	}
+	existingCatalogs = SourceCatalogs(existingCatalogs, provenance.Name)
+	// The dry run returns before the journal is loaded, loading compacts it
+	if fetchDryRun {
+		catalogs, _, complete, err := listCatalogs(ctx, src, p, filter)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := PlanFetch(ctx, src, p.Name, catalogs, existingCatalogs, complete, filter, GetCatalogInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan the fetch: %s", err)
+		}
+		if fetchPlan != "" {
+			return prog, WritePlan(fetchPlan, plan)
+		}
+		return prog, PrintPlan(os.Stdout, plan)
+	}
+	journal, err := loadJournal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the fetch journal: %s", err)
+	}
+
+	var newCatalgos []*datagovin.Catalog
+	var syncState *datagovin.SyncState
+	var highWater time.Time
 	newDatasets := newDatasetColl()
-	fmt.Printf("Found %d new catalogs\n", newCatLen)
+	if fetchResume || fetchRetryFailed {
+		var catalogs []*datagovin.Catalog
+		var datasets []*datagovin.Dataset
+		if fetchRetryFailed {
+			failures, err := ReadFailures(fetchReport)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the failure report: %s", err)
+			}
+			catalogs, datasets = FailedItems(failures)
+		} else {
+			catalogs, datasets = journal.Unfinished(filter)
+		}
+		newCatalgos = ResolveCatalogs(catalogs, existingCatalogs)
+		datasets, err = resumeDatasets(datasets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch data from database: %s", err)
+		}
+		newDatasets.Append(datasets...)
+		fmt.Printf("Retrying %d catalogs and %d datasets\n", len(newCatalgos), len(datasets))
+	} else {
+		var catalogs []*datagovin.Catalog
+		var complete bool
+		catalogs, syncState, complete, err = listCatalogs(ctx, src, p, filter)
+		if err != nil {
+			return nil, err
+		}
+		highWater = latestChange(catalogs)
+
+		topicCatalogs := FilterCatalogs(existingCatalogs, filter)
+		changes := CatalogChanges(p.Name, catalogs, topicCatalogs, complete)
+		if err := saveCatalogChanges(changes, topicCatalogs); err != nil {
+			return nil, fmt.Errorf("failed to record changes: %s", err)
+		}
+		if len(changes) != 0 {
+			fmt.Printf("Recorded %d deleted or retitled catalogs\n", len(changes))
+		}
+
+		newCatalgos = CompareCatalogs(catalogs, existingCatalogs)
+		fmt.Printf("Found %d new catalogs\n", len(newCatalgos))
+	}
+	newCatLen := len(newCatalgos)
+	for _, c := range newCatalgos {
+		if err := journal.Catalog(c, datagovin.JobPending, nil); err != nil {
+			return nil, fmt.Errorf("failed to write the fetch journal: %s", err)
+		}
+	}
 
 	prog.totCatalogs = newCatLen
 
-	wg := util.NewCountableWaitGroup()
-	wg.Add(newCatLen)
+	writer := uilive.New()
+	writer.Start()
+	var catPool *util.Pool
+	catPool = util.NewPool(ctx, concurrency, util.OnTaskDone(func(util.TaskResult) {
+		fmt.Fprintf(writer, "Pending downloads: %d/%d catalogs\n", catPool.Pending(), newCatLen)
+	}))
 	for _, c := range newCatalgos {
-		go func(cat *datagovin.Catalog) {
-			err := SaveCatalog(cat)
-			if err == nil {
-				existingDatasets, err := GetCatalogInfo(cat)
-				if err == nil {
-					datasets, err := requests.FetchCatalogInfo(cat)
-					if err == nil {
-						newDatasets.Append(CompareDataSets(datasets, existingDatasets)...)
-					} else {
-						prog.AddFailedCat()
-					}
-				}
+		cat := c
+		catPool.Submit(func(ctx context.Context) (interface{}, error) {
+			err := fetchCatalog(ctx, src, journal, p.Name, cat, newDatasets)
+			if err != nil {
+				prog.AddFailedCat(cat, err)
+				journal.Catalog(cat, datagovin.JobFailed, err)
 			}
-			wg.Done()
-			fmt.Fprintf(writer, "Pending downloads: %d/%d catalogs\n", wg.Count(), newCatLen)
-		}(c)
+			return nil, err
+		})
 	}
-	wg.Wait()
+	catPool.Wait()
 	writer.Stop()
 
 	if prog.failedCat != 0 {
@@ -147,29 +315,185 @@ func Fetch() {
 
 	writer = uilive.New()
 	writer.Start()
-	wg.Add(newDatasetsSize)
+	var datPool *util.Pool
+	datPool = util.NewPool(ctx, concurrency, util.OnTaskDone(func(util.TaskResult) {
+		fmt.Fprintf(writer, "Pending downloads: %d/%d datasets\n", datPool.Pending(), newDatasetsSize)
+	}))
 	for _, d := range newDatasets.Iter() {
-		go func(dat *datagovin.Dataset) {
-			data, err := requests.FetchData(dat)
-			if err == nil {
-				dat.Data = *data
-				SaveDataset(dat)
-			} else {
-				prog.AddFailedDat()
+		dat := d
+		datPool.Submit(func(ctx context.Context) (interface{}, error) {
+			err := fetchDataset(ctx, src, journal, dat)
+			if err != nil {
+				prog.AddFailedDat(dat, err)
+				journal.Dataset(dat, datagovin.JobFailed, err)
 			}
-			wg.Done()
-			fmt.Fprintf(writer, "Pending downloads: %d/%d datasets\n", wg.Count(), newDatasetsSize)
-		}(d)
+			return nil, err
+		})
 	}
-	wg.Wait()
+	datPool.Wait()
 	writer.Stop()
-	requests.Stop()
+	if err := journal.Compact(); err != nil {
+		fmt.Printf("Failed to compact the fetch journal: %s\n", err)
+	}
 
 	if prog.failedDat != 0 {
 		fmt.Printf("Failed to fetch %d datasets\n", prog.failedDat)
 	}
+	if len(prog.failures) != 0 {
+		PrintFailures(os.Stdout, prog.failures)
+	}
+	if fetchReport != "" {
+		if err := WriteFailures(fetchReport, prog.failures); err != nil {
+			fmt.Printf("Failed to write the failure report: %s\n", err)
+		} else if len(prog.failures) != 0 {
+			fmt.Printf("Failures written to %s, run with --retry-failed to retry them\n", fetchReport)
+		}
+	}
+	if prog.failedCat != 0 || prog.failedDat != 0 || ctx.Err() != nil {
+		fmt.Println("Run with --resume to retry the unfinished work")
+	} else if syncState != nil {
+		// The mark only moves once everything up to it is fetched
+		if highWater.After(syncState.HighWater) {
+			syncState.HighWater = highWater
+		}
+		syncState.LastSync = time.Now()
+		if err := SaveSyncState(syncState); err != nil {
+			fmt.Printf("Failed to save the sync state: %s\n", err)
+		}
+	}
 
 	fmt.Println("Completed!")
+	return prog, nil
+}
+
+// latestChange returns the latest change time of the catalogs
+func latestChange(catalogs []*datagovin.Catalog) time.Time {
+	var latest time.Time
+	for _, c := range catalogs {
+		if c.LastModified.After(latest) {
+			latest = c.LastModified
+		}
+	}
+	return latest
+}
+
+// saveCatalogChanges records the changes and marks the deleted catalogs among
+// existing as removed
+func saveCatalogChanges(changes []*datagovin.Change, existing []*datagovin.Catalog) error {
+	if err := SaveChanges(changes); err != nil {
+		return err
+	}
+	catMap := make(map[uint64]*datagovin.Catalog)
+	for _, c := range existing {
+		catMap[c.CatID] = c
+	}
+	for _, change := range changes {
+		c, ok := catMap[change.ItemID]
+		if !ok || change.Change != datagovin.ChangeDeleted {
+			continue
+		}
+		c.Removed = true
+		if err := SaveCatalog(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDatasetChanges records the changes and marks the deleted datasets among
+// existing as removed
+func saveDatasetChanges(changes []*datagovin.Change, existing []*datagovin.Dataset) error {
+	if err := SaveChanges(changes); err != nil {
+		return err
+	}
+	datasetMap := make(map[uint64]*datagovin.Dataset)
+	for _, d := range existing {
+		datasetMap[d.DID] = d
+	}
+	for _, change := range changes {
+		d, ok := datasetMap[change.ItemID]
+		if !ok || change.Change != datagovin.ChangeDeleted {
+			continue
+		}
+		d.Removed = true
+		if err := SaveDataset(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchCatalog saves the catalog and lists its datasets, journaling the ones
+// that are new before the catalog is marked done. Datasets of the catalog that
+// were deleted or retitled are recorded as changes of topic. Errors are
+// tagged with the stage that failed
+func fetchCatalog(ctx context.Context, src source.Source, j *journal, topic string, cat *datagovin.Catalog, newDatasets *datasetColl) error {
+	err := SaveCatalog(cat)
+	if err != nil {
+		return staged(StageSaveCatalog, err)
+	}
+	if err := j.Catalog(cat, datagovin.JobFetching, nil); err != nil {
+		return staged(StageJournal, err)
+	}
+	existingDatasets, err := GetCatalogInfo(cat)
+	if err != nil {
+		return staged(StageLoadSaved, err)
+	}
+	datasets, err := src.ListDatasets(ctx, cat)
+	if err != nil {
+		return staged(StageListDatasets, err)
+	}
+	for _, d := range datasets {
+		d.Source = src.Provenance().Name
+	}
+	err = saveDatasetChanges(DatasetChanges(topic, datasets, existingDatasets), existingDatasets)
+	if err != nil {
+		return staged(StageRecordChanges, err)
+	}
+	// CompareDataSets updates the saved datasets in place
+	if err := ArchiveDatasets(existingDatasets); err != nil {
+		return staged(StageSaveRevision, err)
+	}
+	datasets = CompareDataSets(datasets, existingDatasets)
+	for _, d := range datasets {
+		if err := j.Dataset(d, datagovin.JobPending, nil); err != nil {
+			return staged(StageJournal, err)
+		}
+	}
+	newDatasets.Append(datasets...)
+	if err := j.Catalog(cat, datagovin.JobDone, nil); err != nil {
+		return staged(StageJournal, err)
+	}
+	return nil
+}
+
+func fetchDataset(ctx context.Context, src source.Source, j *journal, dat *datagovin.Dataset) error {
+	if err := j.Dataset(dat, datagovin.JobFetching, nil); err != nil {
+		return staged(StageJournal, err)
+	}
+	data, err := src.FetchData(ctx, dat)
+	if err != nil {
+		return staged(StageFetchData, err)
+	}
+	dat.Data = *data
+	// A new version is no longer a merged duplicate, dedupe looks at it again
+	dat.DuplicateOf = 0
+	dat.Hash, err = data.Hash()
+	if err != nil {
+		return staged(StageSaveDataset, err)
+	}
+	// The revision is saved first as saving the dataset advances its
+	// LastModified, after which retries and resumes skip the dataset
+	if err := SaveRevision(dat); err != nil {
+		return staged(StageSaveRevision, err)
+	}
+	if err := SaveDataset(dat); err != nil {
+		return staged(StageSaveDataset, err)
+	}
+	if err := j.Dataset(dat, datagovin.JobDone, nil); err != nil {
+		return staged(StageJournal, err)
+	}
+	return nil
 }
 
 func createFiles() (*os.File, error) {
@@ -195,8 +519,18 @@ func createFiles() (*os.File, error) {
 	return file, nil
 }
 
-func Dump() {
+// Dump writes the saved catalogs of the profile and their datasets to a file
+func Dump(p *Profile) {
 	fmt.Println("Initializing...")
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		log.Fatalf("Invalid filter: %s\n", err)
+	}
+	// The source is only asked for its provenance, it sends no request
+	src, err := newSource(p.Source, fetchConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	dumpFile, err := createFiles()
 	if err != nil {
 		log.Fatalln(err.Error())
@@ -214,15 +548,25 @@ func Dump() {
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	catalogs = FilterCatalogs(SourceCatalogs(catalogs, p.Source), filter)
+	data["source"] = src.Provenance()
 	data["catalogs"] = catalogs
 	datasets := make([]*datagovin.Dataset, 0)
 
-	for _, c := range catalogs {
-		ds, err := GetCatalogInfo(c)
-		if err != nil {
+	tasks := make([]util.Task, len(catalogs))
+	for i, c := range catalogs {
+		cat := c
+		tasks[i] = func(ctx context.Context) (interface{}, error) {
+			return GetCatalogInfo(cat)
+		}
+	}
+	// Results keep the order of the catalogs, unreadable catalogs are skipped
+	results, _ := util.RunTasks(context.Background(), concurrency, tasks)
+	for _, res := range results {
+		if res.Err != nil {
 			continue
 		}
-		datasets = append(datasets, ds...)
+		datasets = append(datasets, res.Value.([]*datagovin.Dataset)...)
 	}
 	data["datasets"] = datasets
 
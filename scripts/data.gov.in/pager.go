@@ -0,0 +1,199 @@
+package datagovin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/util"
+)
+
+// DefaultPageSize is the number of records requested per listing page
+const DefaultPageSize = 500
+
+var (
+	// ErrIncompleteListing is returned when data.gov.in stops returning
+	// records before the total it reported
+	ErrIncompleteListing = errors.New("listing ended before its total")
+	// ErrListingChanged is returned when the total of a listing changes
+	// while it is paged through
+	ErrListingChanged = errors.New("listing changed while paging")
+)
+
+// StatusError is returned for listing responses other than 200 OK
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response: " + e.Status
+}
+
+// pager walks through the pages of a data.gov.in listing using the offset and
+// limit parameters, checking that every record reported is received
+type pager struct {
+	r        *requests
+	url      string
+	query    url.Values
+	pageSize int
+
+	received int
+	total    int
+}
+
+func (r *requests) newPager(path string, query url.Values) *pager {
+	pageSize := r.pageSize
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	return &pager{
+		r:        r,
+		url:      r.baseURL + path + "/",
+		query:    query,
+		pageSize: pageSize,
+		total:    -1,
+	}
+}
+
+// next returns the records of the next page and io.EOF after the last one
+func (p *pager) next(ctx context.Context) ([]map[string]interface{}, error) {
+	if p.total >= 0 && p.received >= p.total {
+		if p.received > p.total {
+			return nil, fmt.Errorf("%w: received %d of %d", ErrListingChanged, p.received, p.total)
+		}
+		return nil, io.EOF
+	}
+	p.query.Set("offset", strconv.Itoa(p.received))
+	p.query.Set("limit", strconv.Itoa(p.pageSize))
+	request, err := http.NewRequest("GET", p.url+"?"+p.query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.r.network.DoContext(ctx, request, util.WithPriority(util.PriorityHigh)).Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	page := new(response)
+	err = json.Unmarshal(body, page)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse response: %s", err)
+	}
+	if page.Status != "ok" {
+		return nil, errors.New("response status not ok")
+	}
+	if p.total >= 0 && page.Total != p.total {
+		return nil, fmt.Errorf("%w: total went from %d to %d", ErrListingChanged, p.total, page.Total)
+	}
+	p.total = page.Total
+	if len(page.Records) == 0 && p.received < p.total {
+		return nil, fmt.Errorf("%w: received %d of %d", ErrIncompleteListing, p.received, p.total)
+	}
+	p.received = p.received + len(page.Records)
+	return page.Records, nil
+}
+
+// CatalogIterator streams the catalogs of a listing page by page
+//
+//	it := r.IterCatalogs(ctx, "Census")
+//	for it.Next() {
+//		c := it.Catalog()
+//	}
+//	err := it.Err()
+type CatalogIterator struct {
+	ctx     context.Context
+	pages   *pager
+	buf     []*datagovin.Catalog
+	current *datagovin.Catalog
+	err     error
+	// since ends the listing at the first catalog not changed after it, the
+	// listing is then sorted by change time
+	since time.Time
+	ended bool
+}
+
+// IterCatalogs lists the catalogs matching search, every catalog when it is
+// empty
+func (r *requests) IterCatalogs(ctx context.Context, search string) *CatalogIterator {
+	query := make(url.Values)
+	query.Set("format", "json")
+	query.Set("sort[_score]", "desc")
+	if search != "" {
+		query.Set("query", search)
+	}
+	return &CatalogIterator{
+		ctx:   ctx,
+		pages: r.newPager(CatalogPath, query),
+	}
+}
+
+// IterCatalogsChangedSince lists the catalogs matching search that changed
+// after since, newest first. Only the pages holding such catalogs are requested
+func (r *requests) IterCatalogsChangedSince(ctx context.Context, search string, since time.Time) *CatalogIterator {
+	it := r.IterCatalogs(ctx, search)
+	it.pages.query.Del("sort[_score]")
+	it.pages.query.Set("sort[changed]", "desc")
+	it.since = since
+	return it
+}
+
+// Next advances to the next catalog, it returns false once the listing is
+// exhausted or failed
+func (it *CatalogIterator) Next() bool {
+	if it.ended {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.err != nil {
+			return false
+		}
+		records, err := it.pages.next(it.ctx)
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf, it.err = parseCatalogs(records)
+	}
+	it.current = it.buf[0]
+	it.buf = it.buf[1:]
+	if !it.since.IsZero() && !it.current.LastModified.After(it.since) {
+		it.ended = true
+		it.current = nil
+		return false
+	}
+	return true
+}
+
+// Catalog returns the current catalog
+func (it *CatalogIterator) Catalog() *datagovin.Catalog {
+	return it.current
+}
+
+// Err returns the error that ended the listing, if any
+func (it *CatalogIterator) Err() error {
+	return it.err
+}
+
+// Total returns the number of catalogs reported by data.gov.in, -1 before the
+// first page is received
+func (it *CatalogIterator) Total() int {
+	return it.pages.total
+}
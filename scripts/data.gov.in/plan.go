@@ -0,0 +1,216 @@
+package datagovin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/source"
+	"github.com/zeu5/visualizations/util"
+)
+
+// PlanAction tells why a catalog or dataset would be fetched
+type PlanAction string
+
+const (
+	PlanNew      PlanAction = "new"
+	PlanUpdated  PlanAction = "updated"
+	PlanRestored PlanAction = "restored"
+)
+
+// PlanItem is a catalog or dataset a fetch would save
+type PlanItem struct {
+	Kind         datagovin.ItemKind `json:"kind"`
+	ID           uint64             `json:"id"`
+	CatID        uint64             `json:"cat_id"`
+	Title        string             `json:"title"`
+	Action       PlanAction         `json:"action"`
+	LastModified time.Time          `json:"last_modified"`
+	// SavedLastModified and Delta are set for items that are saved already
+	SavedLastModified *time.Time `json:"saved_last_modified,omitempty"`
+	Delta             string     `json:"delta,omitempty"`
+}
+
+// Plan is what a fetch of the profile would do, without saving anything
+type Plan struct {
+	Profile  string              `json:"profile"`
+	Source   source.Provenance   `json:"source"`
+	Catalogs []*PlanItem         `json:"catalogs"`
+	Datasets []*PlanItem         `json:"datasets"`
+	Changes  []*datagovin.Change `json:"changes"`
+	// Requests estimates the requests of the fetch after the catalog listing,
+	// the dataset listing pages and the data of every dataset. It is 0 for
+	// sources that can not estimate them
+	Requests int `json:"requests"`
+	// Failures are the catalogs whose datasets could not be listed
+	Failures []*Failure `json:"failures"`
+
+	lock *sync.Mutex
+}
+
+func newPlan(profile string) *Plan {
+	return &Plan{
+		Profile:  profile,
+		Catalogs: make([]*PlanItem, 0),
+		Datasets: make([]*PlanItem, 0),
+		Changes:  make([]*datagovin.Change, 0),
+		Failures: make([]*Failure, 0),
+		lock:     new(sync.Mutex),
+	}
+}
+
+// Count returns the number of items of the kind planned with the action
+func (p *Plan) Count(kind datagovin.ItemKind, action PlanAction) int {
+	items := p.Catalogs
+	if kind == datagovin.ItemDataset {
+		items = p.Datasets
+	}
+	count := 0
+	for _, i := range items {
+		if i.Action == action {
+			count = count + 1
+		}
+	}
+	return count
+}
+
+type savedVersion struct {
+	lastModified time.Time
+	removed      bool
+}
+
+func planItem(kind datagovin.ItemKind, id, catID uint64, title string, lastModified time.Time, saved map[uint64]savedVersion) *PlanItem {
+	item := &PlanItem{
+		Kind:         kind,
+		ID:           id,
+		CatID:        catID,
+		Title:        title,
+		Action:       PlanNew,
+		LastModified: lastModified,
+	}
+	s, ok := saved[id]
+	if !ok {
+		return item
+	}
+	item.Action = PlanUpdated
+	if s.removed {
+		item.Action = PlanRestored
+	}
+	savedLastModified := s.lastModified
+	item.SavedLastModified = &savedLastModified
+	item.Delta = lastModified.Sub(savedLastModified).Round(time.Second).String()
+	return item
+}
+
+// PlanFetch compares the listed catalogs with the saved ones and lists the
+// datasets of the new and updated catalogs to plan a fetch, comparing them
+// with the datasets saved returns for their catalog. Only the listings are
+// requested, nothing is saved
+func PlanFetch(ctx context.Context, src source.Source, topic string, catalogs, existing []*datagovin.Catalog, complete bool, filter Filter, saved func(*datagovin.Catalog) ([]*datagovin.Dataset, error)) (*Plan, error) {
+	plan := newPlan(topic)
+	plan.Source = src.Provenance()
+	// Compare updates the saved items in place, their versions are kept first
+	savedCatalogs := make(map[uint64]savedVersion)
+	for _, c := range existing {
+		savedCatalogs[c.CatID] = savedVersion{lastModified: c.LastModified, removed: c.Removed}
+	}
+	plan.Changes = append(plan.Changes, CatalogChanges(topic, catalogs, FilterCatalogs(existing, filter), complete)...)
+
+	newCatalogs := CompareCatalogs(catalogs, existing)
+	for _, c := range newCatalogs {
+		plan.Catalogs = append(plan.Catalogs, planItem(datagovin.ItemCatalog, c.CatID, c.CatID, c.Title, c.LastModified, savedCatalogs))
+	}
+
+	estimator, estimates := src.(source.RequestEstimator)
+	pool := util.NewPool(ctx, concurrency)
+	for _, c := range newCatalogs {
+		cat := c
+		pool.Submit(func(ctx context.Context) (interface{}, error) {
+			existingDatasets, err := saved(cat)
+			if err != nil {
+				return nil, staged(StageLoadSaved, err)
+			}
+			datasets, err := src.ListDatasets(ctx, cat)
+			if err != nil {
+				return nil, staged(StageListDatasets, err)
+			}
+			savedDatasets := make(map[uint64]savedVersion)
+			for _, d := range existingDatasets {
+				savedDatasets[d.DID] = savedVersion{lastModified: d.LastModified, removed: d.Removed}
+			}
+			changes := DatasetChanges(topic, datasets, existingDatasets)
+			items := make([]*PlanItem, 0)
+			for _, d := range CompareDataSets(datasets, existingDatasets) {
+				items = append(items, planItem(datagovin.ItemDataset, d.DID, d.CatID, d.Title, d.LastModified, savedDatasets))
+			}
+
+			plan.lock.Lock()
+			plan.Datasets = append(plan.Datasets, items...)
+			plan.Changes = append(plan.Changes, changes...)
+			if estimates {
+				plan.Requests = plan.Requests + estimator.EstimateRequests(len(datasets), len(items))
+			}
+			plan.lock.Unlock()
+			return nil, nil
+		})
+	}
+	results, _ := pool.Wait()
+	for i, res := range results {
+		if res.Err != nil {
+			plan.Failures = append(plan.Failures, catalogFailure(newCatalogs[i], res.Err))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PrintPlan prints the items of the plan and its totals
+func PrintPlan(out io.Writer, plan *Plan) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tID\tCATALOG\tACTION\tLAST MODIFIED\tDELTA\tTITLE")
+	for _, items := range [][]*PlanItem{plan.Catalogs, plan.Datasets} {
+		for _, i := range items {
+			delta := "-"
+			if i.Delta != "" {
+				delta = i.Delta
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", i.Kind, i.ID, i.CatID, i.Action, formatDate(i.LastModified), delta, i.Title)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, kind := range []datagovin.ItemKind{datagovin.ItemCatalog, datagovin.ItemDataset} {
+		fmt.Fprintf(out, "%ss: %d new, %d updated, %d restored\n", kind,
+			plan.Count(kind, PlanNew), plan.Count(kind, PlanUpdated), plan.Count(kind, PlanRestored))
+	}
+	if len(plan.Changes) != 0 {
+		fmt.Fprintf(out, "%d deleted or retitled items would be recorded\n", len(plan.Changes))
+	}
+	fmt.Fprintf(out, "About %d requests after the catalog listing\n", plan.Requests)
+	if len(plan.Failures) != 0 {
+		fmt.Fprintf(out, "Could not list the datasets of %d catalogs, the estimate leaves them out\n", len(plan.Failures))
+		return PrintFailures(out, plan.Failures)
+	}
+	return nil
+}
+
+// WritePlan writes the plan as JSON
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode plan: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write plan: %s", err)
+	}
+	return nil
+}
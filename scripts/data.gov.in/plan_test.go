@@ -0,0 +1,143 @@
+package datagovin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/data.gov.in/fake"
+	"github.com/zeu5/visualizations/scripts/source"
+)
+
+// planPortal serves catalogs 1 to 3, catalog 1 holding datasets 101 and 102
+// and catalog 2 holding 201, changed after it was saved, and 202
+func planPortal() *fake.Portal {
+	p := testPortal(3, 2)
+	p.Catalogs[1].Datasets = []*fake.Dataset{
+		{ID: 201, Title: "Table 1", Created: changed, Changed: changed.Add(2 * time.Hour)},
+		{ID: 202, Title: "Table 2", Created: changed, Changed: changed},
+	}
+	return p
+}
+
+// planSaved is what the database holds for planPortal: catalog 2 before its
+// change, catalog 3 as listed and catalog 4 which is no longer listed
+func planSaved() ([]*datagovin.Catalog, map[uint64][]*datagovin.Dataset) {
+	catalogs := []*datagovin.Catalog{
+		{CatID: 2, Title: "Crime in India 2", LastModified: changed},
+		{CatID: 3, Title: "Crime in India 3", LastModified: changed.Add(3 * time.Hour)},
+		{CatID: 4, Title: "Gone", LastModified: changed},
+	}
+	datasets := map[uint64][]*datagovin.Dataset{
+		2: {
+			{DID: 201, CatID: 2, Title: "Table 1", LastModified: changed},
+			{DID: 202, CatID: 2, Title: "Table 2", LastModified: changed, Removed: true},
+			{DID: 203, CatID: 2, Title: "Gone table", LastModified: changed},
+		},
+	}
+	return catalogs, datasets
+}
+
+func planItemsOf(items []*PlanItem) []string {
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	result := make([]string, len(items))
+	for i, item := range items {
+		result[i] = fmt.Sprintf("%d %s %s", item.ID, item.Action, item.Delta)
+	}
+	return result
+}
+
+func TestPlanFetch(t *testing.T) {
+	srv := fake.NewServer(planPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+	catalogs, err := r.ListCatalogs(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, savedDatasets := planSaved()
+	saved := func(c *datagovin.Catalog) ([]*datagovin.Dataset, error) {
+		return savedDatasets[c.CatID], nil
+	}
+
+	plan, err := PlanFetch(ctx, r, "crime", catalogs, existing, true, matchAll{}, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := planItemsOf(plan.Catalogs), []string{"1 new ", "2 updated 2h0m0s"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("planned catalogs %q, want %q", got, want)
+	}
+	want := []string{"101 new ", "102 new ", "201 updated 2h0m0s", "202 restored 0s"}
+	if got := planItemsOf(plan.Datasets); !reflect.DeepEqual(got, want) {
+		t.Fatalf("planned datasets %q, want %q", got, want)
+	}
+	changes := make(map[uint64]datagovin.ChangeKind)
+	for _, c := range plan.Changes {
+		changes[c.ItemID] = c.Change
+	}
+	if len(changes) != 2 || changes[4] != datagovin.ChangeDeleted || changes[203] != datagovin.ChangeDeleted {
+		t.Fatalf("got changes %v, want catalog 4 and dataset 203 deleted", changes)
+	}
+	// A listing page and the token and export of 2 datasets per catalog
+	if plan.Requests != 10 || len(plan.Failures) != 0 {
+		t.Fatalf("estimated %d requests with %d failures", plan.Requests, len(plan.Failures))
+	}
+	if plan.Count(datagovin.ItemDataset, PlanNew) != 2 || plan.Source.Name != DefaultSource {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	// An incremental listing records no deletions of catalogs
+	existing, _ = planSaved()
+	plan, err = PlanFetch(ctx, r, "crime", catalogs[:1], existing, false, matchAll{}, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 || len(plan.Catalogs) != 1 {
+		t.Fatalf("got %d changes and %d catalogs", len(plan.Changes), len(plan.Catalogs))
+	}
+}
+
+func TestPlanFetchFailures(t *testing.T) {
+	srv := fake.NewServer(planPortal())
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+	ctx := context.Background()
+	catalogs, err := r.ListCatalogs(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, _ := planSaved()
+	failed := errors.New("database down")
+	saved := func(c *datagovin.Catalog) ([]*datagovin.Dataset, error) {
+		if c.CatID == 2 {
+			return nil, failed
+		}
+		return nil, nil
+	}
+
+	plan, err := PlanFetch(ctx, r, "crime", catalogs, existing, true, matchAll{}, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Failures) != 1 || plan.Failures[0].ItemID != 2 || plan.Failures[0].Stage != StageLoadSaved {
+		t.Fatalf("got failures %+v", plan.Failures)
+	}
+	// The estimate leaves out the catalog that failed
+	if got := planItemsOf(plan.Datasets); plan.Requests != 5 || len(got) != 2 {
+		t.Fatalf("planned datasets %q with %d requests", got, plan.Requests)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := PlanFetch(cancelled, r, "crime", catalogs, existing, true, matchAll{}, saved); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
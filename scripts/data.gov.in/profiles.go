@@ -0,0 +1,149 @@
+package datagovin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/zeu5/visualizations/scripts/source"
+	"github.com/zeu5/visualizations/util"
+)
+
+// Profile names a topic of data.gov.in and selects its catalogs
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Source is the portal the catalogs are fetched from, data.gov.in when
+	// empty
+	Source string `json:"source,omitempty"`
+	// Query is sent to data.gov.in to narrow down the catalogs listed
+	Query string `json:"query"`
+	// Filter is an expression parsed by ParseFilter over the listed catalogs
+	Filter string `json:"filter"`
+	// Schedule is the cron expression the sync daemon runs the profile on,
+	// the daemon leaves out profiles without one
+	Schedule string `json:"schedule,omitempty"`
+	// Summarise builds the tables of the topic after the profile is synced
+	// with the summary registered for the topic, only profiles of topics
+	// with a summary can set it
+	Summarise bool `json:"summarise,omitempty"`
+}
+
+// CrimeProfile is the topic the scripts were first written for
+var CrimeProfile = &Profile{
+	Name:        "crime",
+	Description: "Crime in India reports of the National Crime Records Bureau",
+	Query:       "Crime in India",
+	Filter:      `title~"Crime in India"`,
+	Summarise:   true,
+}
+
+// SummaryFunc builds the tables of a topic from the saved catalogs of its
+// profile, the database is initialized before it runs
+type SummaryFunc func(ctx context.Context, p *Profile) error
+
+type topic struct {
+	profile   *Profile
+	summarise SummaryFunc
+}
+
+// topics are the built-in profiles by name
+var topics = map[string]*topic{
+	CrimeProfile.Name: {profile: CrimeProfile, summarise: summariseCrime},
+}
+
+// RegisterTopic adds p to the built-in profiles, summarised by summarise.
+// Packages of topics of other portals register from an init function, it
+// panics if the name is already taken or p is summarised without a summary
+func RegisterTopic(p *Profile, summarise SummaryFunc) {
+	if _, ok := topics[p.Name]; ok {
+		panic("topic registered twice: " + p.Name)
+	}
+	if p.Summarise && summarise == nil {
+		panic("topic summarised without a summary: " + p.Name)
+	}
+	topics[p.Name] = &topic{profile: p, summarise: summarise}
+}
+
+// summaryOf returns the summary of the topic of the profile, profiles of
+// the config file keep the summary of the topic they override. It is nil
+// for topics without a summary
+func summaryOf(p *Profile) SummaryFunc {
+	if t, ok := topics[p.Name]; ok {
+		return t.summarise
+	}
+	return nil
+}
+
+// ProfilesConfig is the config file listing additional profiles, such as
+//
+//	{"profiles": [{"name": "census", "query": "Census", "filter": "title~\"Census\"", "schedule": "0 3 * * *"}]}
+type ProfilesConfig struct {
+	Profiles []*Profile `json:"profiles"`
+}
+
+// Profiles are the profiles known to the scripts by name
+type Profiles map[string]*Profile
+
+// LoadProfiles returns the built-in profiles along with the ones of the config
+// file at path, which override built-in profiles of the same name
+func LoadProfiles(path string) (Profiles, error) {
+	profiles := make(Profiles)
+	for name, t := range topics {
+		profiles[name] = t.profile
+	}
+	if path == "" {
+		return profiles, nil
+	}
+	fileContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profiles file: %s", err)
+	}
+	var config ProfilesConfig
+	err = json.Unmarshal(fileContents, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file: %s", err)
+	}
+	for _, p := range config.Profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("profile without a name in %s", path)
+		}
+		if p.Source != "" && !source.Registered(p.Source) {
+			return nil, fmt.Errorf("unknown source of profile %s: %s", p.Name, p.Source)
+		}
+		if _, err := ParseFilter(p.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter of profile %s: %s", p.Name, err)
+		}
+		if p.Schedule != "" {
+			if _, err := util.ParseSchedule(p.Schedule); err != nil {
+				return nil, fmt.Errorf("invalid schedule of profile %s: %s", p.Name, err)
+			}
+		}
+		if p.Summarise && summaryOf(p) == nil {
+			return nil, fmt.Errorf("profile %s sets summarise but its topic has no summary", p.Name)
+		}
+		profiles[p.Name] = p
+	}
+	return profiles, nil
+}
+
+// Get returns the named profile
+func (p Profiles) Get(name string) (*Profile, error) {
+	profile, ok := p[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+	return profile, nil
+}
+
+// Names returns the names of the profiles in order
+func (p Profiles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
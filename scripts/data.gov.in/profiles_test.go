@@ -0,0 +1,92 @@
+package datagovin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProfiles(t *testing.T, config string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "profiles.json")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"new topic", `{"profiles": [{"name": "census", "query": "Census", "filter": "title~\"Census\""}]}`, ""},
+		{"summarised built-in topic", `{"profiles": [{"name": "crime", "query": "Crime", "summarise": true}]}`, ""},
+		{"summarised new topic", `{"profiles": [{"name": "census", "summarise": true}]}`, "profile census sets summarise but its topic has no summary"},
+		{"no name", `{"profiles": [{"query": "Census"}]}`, "profile without a name"},
+		{"unknown source", `{"profiles": [{"name": "census", "source": "census.gov.in"}]}`, "unknown source of profile census"},
+		{"invalid filter", `{"profiles": [{"name": "census", "filter": "title~"}]}`, "invalid filter of profile census"},
+		{"invalid schedule", `{"profiles": [{"name": "census", "schedule": "0 25 * * *"}]}`, "invalid schedule of profile census"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeProfiles(t, tt.config)
+			defer cleanup()
+			profiles, err := LoadProfiles(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := profiles.Get(CrimeProfile.Name); err != nil {
+				t.Fatal("built-in profile missing")
+			}
+		})
+	}
+}
+
+func TestSummaryOf(t *testing.T) {
+	if summaryOf(CrimeProfile) == nil {
+		t.Fatal("crime profile without a summary")
+	}
+	// Profiles of the config file keep the summary of the topic they override
+	if summaryOf(&Profile{Name: CrimeProfile.Name, Query: "Crime"}) == nil {
+		t.Fatal("overridden crime profile without a summary")
+	}
+	census := &Profile{Name: "census", Summarise: true}
+	if summaryOf(census) != nil {
+		t.Fatal("unknown topic summarised")
+	}
+	if err := summarise(context.Background(), census); err == nil {
+		t.Fatal("summarised a topic without a summary")
+	}
+}
+
+func TestTopicCmdSummary(t *testing.T) {
+	hasSummary := func(p *Profile) bool {
+		for _, c := range TopicCmd("topic", "", p).Commands() {
+			if c.Name() == "summary" {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasSummary(CrimeProfile) {
+		t.Fatal("summarised topic without a summary command")
+	}
+	if hasSummary(&Profile{Name: "census"}) {
+		t.Fatal("summary command of a topic that is not summarised")
+	}
+}
@@ -1,7 +1,7 @@
 package datagovin
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,30 +14,61 @@ import (
 
 	"github.com/mitchellh/mapstructure"
 	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/source"
 	"github.com/zeu5/visualizations/util"
 )
 
+// BaseURL is the data.gov.in portal, the paths below are relative to it
+const BaseURL = "https://data.gov.in"
+
 const (
-	BaseURL       = "https://data.gov.in"
-	CatalogURL    = BaseURL + "/cust-api/v1"
-	DatasetURL    = CatalogURL + "/resource"
-	TokenURL      = BaseURL + "/save_reasons"
-	DataURL       = BaseURL + "/node"
-	DataURLSuffix = "/datastore/export/json"
+	CatalogPath    = "/cust-api/v1"
+	DatasetPath    = CatalogPath + "/resource"
+	TokenPath      = "/save_reasons"
+	DataPath       = "/node"
+	DataPathSuffix = "/datastore/export/json"
 )
 
+// requests is the data.gov.in source
 type requests struct {
-	network *util.ThrottledClient
+	network  *util.ThrottledClient
+	baseURL  string
+	pageSize int
 }
 
-func noTimeout(c *http.Client) {
-	c.Timeout = time.Duration(0)
-}
+const (
+	// maxExportSize is the largest dataset export downloaded
+	maxExportSize = 1 << 30
+	// spoolSize is the size above which exports are buffered on disk
+	spoolSize = 16 << 20
+)
 
-func newRequests() *requests {
-	return &requests{
-		network: util.NewThrottledClient(200*time.Millisecond, 10, noTimeout),
+// Lane is the ThrottledClient lane used for data.gov.in
+const Lane = "data.gov.in"
+
+// newRequests leaves the download token out of the keys of fixtures and
+// cached responses so that they replay regardless of the token issued. Token
+// requests are cached as well so that offline runs replay downloads
+func newRequests(c source.Config) (*requests, error) {
+	network, baseURL, err := c.NewClient(source.Client{
+		BaseURL:      BaseURL,
+		Lane:         Lane,
+		IgnoreParams: []string{"token"},
+		Options: []util.ClientOptions{
+			util.WithBuffering(util.BufferOptions{
+				MaxSize:        maxExportSize,
+				SpoolThreshold: spoolSize,
+			}),
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &requests{
+		network:  network,
+		baseURL:  baseURL,
+		pageSize: c.PageSize,
+	}, nil
 }
 
 func (r *requests) Start() {
@@ -55,42 +86,56 @@ type response struct {
 	Count   int                      `json:"count"`
 }
 
-func (r *requests) FetchCatalogs() ([]*datagovin.Catalog, error) {
+// Provenance describes data.gov.in
+func (r *requests) Provenance() source.Provenance {
+	return source.Provenance{
+		Name:      DefaultSource,
+		URL:       r.baseURL,
+		Publisher: "Open Government Data Platform India",
+		License:   "Government Open Data License - India",
+	}
+}
 
-	query := make(url.Values)
-	query.Add("format", "json")
-	query.Add("offset", "0")
-	query.Add("limit", "7000")
-	query.Add("sort[_score]", "desc")
-	query.Add("query", "Crime in India")
-	request, err := http.NewRequest("GET", CatalogURL+"/?"+query.Encode(), nil)
-	if err != nil {
+// EstimateRequests counts the listing pages of the datasets and the token and
+// export requests of each dataset fetched
+func (r *requests) EstimateRequests(listed, fetched int) int {
+	pageSize := r.pageSize
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	pages := (listed + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	return pages + fetched*dataRequestsPerDataset
+}
+
+// ListCatalogs searches data.gov.in for catalogs matching search, every
+// catalog is returned when it is empty
+func (r *requests) ListCatalogs(ctx context.Context, search string) ([]*datagovin.Catalog, error) {
+	catalogs := make([]*datagovin.Catalog, 0)
+	it := r.IterCatalogs(ctx, search)
+	for it.Next() {
+		catalogs = append(catalogs, it.Catalog())
+	}
+	if err := it.Err(); err != nil {
 		return []*datagovin.Catalog{}, err
 	}
+	return catalogs, nil
+}
 
-	reqRes, err := r.network.Do(request)
-	if err != nil {
-		return nil, err
+// ListCatalogsChangedSince is ListCatalogs for the catalogs changed after
+// since, it lists every catalog when since is zero
+func (r *requests) ListCatalogsChangedSince(ctx context.Context, search string, since time.Time) ([]*datagovin.Catalog, error) {
+	catalogs := make([]*datagovin.Catalog, 0)
+	it := r.IterCatalogsChangedSince(ctx, search, since)
+	for it.Next() {
+		catalogs = append(catalogs, it.Catalog())
 	}
-	select {
-	case resp := <-reqRes.Response:
-		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return []*datagovin.Catalog{}, fmt.Errorf("could not read response: %s", err.Error())
-		}
-		var respS response
-		err = json.Unmarshal(body, &respS)
-		if err != nil {
-			return []*datagovin.Catalog{}, fmt.Errorf("could not parse response: %s", err.Error())
-		}
-		if respS.Status != "ok" {
-			return []*datagovin.Catalog{}, errors.New("response status not ok")
-		}
-		return parseCatalogs(respS.Records)
-	case err := <-reqRes.Error:
+	if err := it.Err(); err != nil {
 		return []*datagovin.Catalog{}, err
 	}
+	return catalogs, nil
 }
 
 type catalogRecord struct {
@@ -126,56 +171,24 @@ func parseCatalogs(records []map[string]interface{}) ([]*datagovin.Catalog, erro
 	return catalogs, nil
 }
 
-func (r *requests) FetchCatalogInfo(c *datagovin.Catalog) ([]*datagovin.Dataset, error) {
+// ListDatasets lists the datasets of the catalog
+func (r *requests) ListDatasets(ctx context.Context, c *datagovin.Catalog) ([]*datagovin.Dataset, error) {
 	query := make(url.Values)
 	query.Set("filters[field_catalog_reference]", strconv.FormatUint(c.CatID, 10))
 	query.Set("format", "json")
 	query.Set("sort[created]", "desc")
 
-	totLen := -1
-	count := 0
-
-	responses := make([]*response, 0)
+	pages := r.newPager(DatasetPath, query)
+	datasetRecords := make([]map[string]interface{}, 0)
 	for {
-		if totLen == count {
+		records, err := pages.next(ctx)
+		if err == io.EOF {
 			break
 		}
-
-		request, err := http.NewRequest("GET", DatasetURL+"/?"+query.Encode(), nil)
-		if err != nil {
-			return []*datagovin.Dataset{}, err
-		}
-		reqRes, err := r.network.Do(request)
 		if err != nil {
 			return []*datagovin.Dataset{}, err
 		}
-		select {
-		case resp := <-reqRes.Response:
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				resp.Body.Close()
-				return []*datagovin.Dataset{}, fmt.Errorf("failed to read response body: %s", err.Error())
-			}
-			rS := new(response)
-			err = json.Unmarshal(body, rS)
-			if err != nil {
-				resp.Body.Close()
-				return []*datagovin.Dataset{}, fmt.Errorf("failed to parse response body: %s", err.Error())
-			}
-			responses = append(responses, rS)
-			totLen = rS.Total
-			count = count + rS.Count
-
-			query.Set("offset", strconv.Itoa(count))
-			query.Set("limit", strconv.Itoa(totLen))
-			resp.Body.Close()
-		case err := <-reqRes.Error:
-			return []*datagovin.Dataset{}, err
-		}
-	}
-	datasetRecords := make([]map[string]interface{}, 0)
-	for _, resp := range responses {
-		datasetRecords = append(datasetRecords, resp.Records...)
+		datasetRecords = append(datasetRecords, records...)
 	}
 	return parseDatasets(c, datasetRecords)
 }
@@ -211,83 +224,95 @@ func parseDatasets(c *datagovin.Catalog, records []map[string]interface{}) ([]*d
 	return datasets, nil
 }
 
+// dataRequestsPerDataset is the token and the export request of FetchData
+const dataRequestsPerDataset = 2
+
+// dataRequestGroup requests a download token and then the json export of
+// the dataset with it. The export response is left for FetchData to decode
 type dataRequestGroup struct {
+	baseURL string
 	token   string
-	data    []byte
 	dataset *datagovin.Dataset
 }
 
+var (
+	// ErrNoToken is returned when data.gov.in does not issue a download token
+	ErrNoToken = errors.New("no download token issued")
+	// ErrNoData is returned when the export of a dataset is empty
+	ErrNoData = errors.New("no data fetched")
+)
+
 type dataResponse struct {
 	Fields []map[string]string `json:"fields"`
 	Data   [][]interface{}     `json:"data"`
 }
 
-func (d *dataRequestGroup) Next(resp *http.Response) *http.Request {
+func (d *dataRequestGroup) Next(resp *http.Response) (*http.Request, error) {
 	if resp == nil {
 		data := url.Values{}
 		data.Set("reasons", `{"download_reasons":"2"}`)
 		data.Set("reasons1", `{"reasons_d[3]":"3","reasons_d[4]":"4"}`)
-		data.Set("reasons2", `{"node_id":"`+strconv.FormatUint(d.dataset.DID, 10)+`","file_for_mat":"xls","redirect_url":"/catalog/crime-india-2016","name_d":"","mail_d":"","form_id":"download_confirmation_resources_form"}`)
+		// The form is submitted from the page of the catalog, which the portal
+		// serves under the node of the catalog as well as under its alias
+		catalogPage := DataPath + "/" + strconv.FormatUint(d.dataset.CatID, 10)
+		data.Set("reasons2", `{"node_id":"`+strconv.FormatUint(d.dataset.DID, 10)+`","file_for_mat":"xls","redirect_url":"`+catalogPage+`","name_d":"","mail_d":"","form_id":"download_confirmation_resources_form"}`)
 
-		tokenReq, err := http.NewRequest("POST", TokenURL, strings.NewReader(data.Encode()))
-		tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		tokenReq, err := http.NewRequest("POST", d.baseURL+TokenPath, strings.NewReader(data.Encode()))
 		if err != nil {
-			return nil
+			return nil, err
 		}
-		return tokenReq
+		tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return tokenReq, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
 	}
 	if d.token == "" {
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			resp.Body.Close()
-			return nil
+			return nil, fmt.Errorf("could not read token: %s", err)
+		}
+		d.token = strings.TrimSpace(string(body))
+		if d.token == "" {
+			return nil, ErrNoToken
 		}
-		d.token = string(body)
 
-		dataReq, err := http.NewRequest(
+		return http.NewRequest(
 			"GET",
-			DataURL+"/"+strconv.FormatUint(d.dataset.DID, 10)+DataURLSuffix+"/?token="+d.token,
+			d.baseURL+DataPath+"/"+strconv.FormatUint(d.dataset.DID, 10)+DataPathSuffix+"/?token="+d.token,
 			nil,
 		)
-		resp.Body.Close()
-		if err != nil {
-			return nil
-		}
-		return dataReq
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err == nil {
-		d.data = body
 	}
-	return nil
+	return nil, nil
 }
 
-func (r *requests) FetchData(d *datagovin.Dataset) (*datagovin.Data, error) {
+func (r *requests) FetchData(ctx context.Context, d *datagovin.Dataset) (*datagovin.Data, error) {
 	reqGroup := &dataRequestGroup{
+		baseURL: r.baseURL,
 		token:   "",
-		data:    []byte{},
 		dataset: d,
 	}
-	reqRes, err := r.network.DoGroup(reqGroup)
+	future := r.network.DoGroupContext(ctx, reqGroup, util.OnLane(Lane), util.WithPriority(util.PriorityLow))
+	responses, err := future.WaitAll(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to submit data req: %s", err)
+		return nil, fmt.Errorf("failed to fetch data: %w", err)
 	}
-	select {
-	case <-reqRes.ResponseGroup:
-		if bytes.Equal(reqGroup.data, []byte{}) {
-			return nil, errors.New("no data fetched")
-		}
-		var dataResp dataResponse
-		err := json.Unmarshal(reqGroup.data, &dataResp)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshall data: %s", err)
+	defer func() {
+		for _, resp := range responses {
+			resp.Body.Close()
 		}
-		return &datagovin.Data{
-			Fields:  dataResp.Fields,
-			Entries: dataResp.Data,
-		}, nil
-	case err := <-reqRes.Error:
-		return nil, fmt.Errorf("failed to fetch data: %s", err)
+	}()
+	// Large exports are spooled to disk by the client and decoded from there
+	var dataResp dataResponse
+	err = json.NewDecoder(responses[len(responses)-1].Body).Decode(&dataResp)
+	if err == io.EOF {
+		return nil, ErrNoData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshall data: %s", err)
 	}
+	return &datagovin.Data{
+		Fields:  dataResp.Fields,
+		Entries: dataResp.Data,
+	}, nil
 }
@@ -0,0 +1,213 @@
+package datagovin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/data.gov.in/fake"
+	"github.com/zeu5/visualizations/scripts/source"
+	"github.com/zeu5/visualizations/scripts/source/sourcetest"
+)
+
+var changed = time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// testPortal serves catalogs 1 to catalogs, catalog 1 holding datasets
+// datasets 101 onwards
+func testPortal(catalogs, datasets int) *fake.Portal {
+	p := fake.NewPortal()
+	for i := 1; i <= catalogs; i++ {
+		p.Catalogs = append(p.Catalogs, &fake.Catalog{
+			ID:          uint64(i),
+			Title:       fmt.Sprintf("Crime in India %d", i),
+			Departments: []string{"Ministry of Home Affairs"},
+			Created:     changed,
+			Changed:     changed.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	for i := 1; i <= datasets; i++ {
+		p.Catalogs[0].Datasets = append(p.Catalogs[0].Datasets, &fake.Dataset{
+			ID:      uint64(100 + i),
+			Title:   fmt.Sprintf("Table %d", i),
+			Created: changed,
+			Changed: changed,
+			Fields:  []map[string]string{{"id": "state", "label": "State"}, {"id": "cases", "label": "Cases"}},
+			Data:    [][]interface{}{{"Kerala", float64(i)}, {"Goa", float64(2 * i)}},
+		})
+	}
+	return p
+}
+
+func newTestRequests(t *testing.T, c source.Config) *requests {
+	t.Helper()
+	r, err := newRequests(sourcetest.Config(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	return r
+}
+
+func catalogIDs(catalogs []*datagovin.Catalog) []uint64 {
+	ids := make([]uint64, len(catalogs))
+	for i, c := range catalogs {
+		ids[i] = c.CatID
+	}
+	return ids
+}
+
+func datasetIDs(datasets []*datagovin.Dataset) []uint64 {
+	ids := make([]uint64, len(datasets))
+	for i, d := range datasets {
+		ids[i] = d.DID
+	}
+	return ids
+}
+
+func TestListCatalogs(t *testing.T) {
+	srv := fake.NewServer(testPortal(23, 0))
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL, PageSize: 5})
+	defer r.Stop()
+
+	catalogs, err := r.ListCatalogs(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalogs) != 23 {
+		t.Fatalf("got %d catalogs, want 23", len(catalogs))
+	}
+	c := catalogs[2]
+	if c.CatID != 3 || c.Title != "Crime in India 3" || !c.LastModified.Equal(changed.Add(3*time.Hour)) ||
+		!reflect.DeepEqual(c.Departments, []string{"Ministry of Home Affairs"}) {
+		t.Fatalf("unexpected catalog %+v", c)
+	}
+
+	catalogs, err = r.ListCatalogs(context.Background(), "india 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := catalogIDs(catalogs); !reflect.DeepEqual(ids, []uint64{2, 20, 21, 22, 23}) {
+		t.Fatalf("got catalogs %v", ids)
+	}
+}
+
+func TestListCatalogsChangedSince(t *testing.T) {
+	srv := fake.NewServer(testPortal(23, 0))
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL, PageSize: 5})
+	defer r.Stop()
+
+	catalogs, err := r.ListCatalogsChangedSince(context.Background(), "", changed.Add(20*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := catalogIDs(catalogs); !reflect.DeepEqual(ids, []uint64{23, 22, 21}) {
+		t.Fatalf("got catalogs %v", ids)
+	}
+}
+
+// TestListDatasetsPortalPageSize pages through a listing whose pages are cut
+// short by the portal
+func TestListDatasetsPortalPageSize(t *testing.T) {
+	p := testPortal(1, 17)
+	p.PageSize = 4
+	srv := fake.NewServer(p)
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL, PageSize: 10})
+	defer r.Stop()
+
+	catalog := &datagovin.Catalog{CatID: 1}
+	datasets, err := r.ListDatasets(context.Background(), catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets) != 17 {
+		t.Fatalf("got %d datasets, want 17", len(datasets))
+	}
+	for i, d := range datasets {
+		if d.DID != uint64(101+i) || d.CatID != 1 || d.Title != fmt.Sprintf("Table %d", i+1) {
+			t.Fatalf("unexpected dataset %d: %+v", i, d)
+		}
+	}
+}
+
+func TestFetchData(t *testing.T) {
+	p := testPortal(3, 2)
+	p.Catalogs[2].Datasets = []*fake.Dataset{{
+		ID:     301,
+		Title:  "Table 1",
+		Fields: []map[string]string{{"id": "state", "label": "State"}},
+		Data:   [][]interface{}{{"Assam"}},
+	}}
+	srv := fake.NewServer(p)
+	defer srv.Close()
+	r := newTestRequests(t, source.Config{BaseURL: srv.URL})
+	defer r.Stop()
+
+	data, err := r.FetchData(context.Background(), &datagovin.Dataset{DID: 102, CatID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Fields) != 2 || data.Fields[1]["id"] != "cases" {
+		t.Fatalf("unexpected fields %v", data.Fields)
+	}
+	want := [][]interface{}{{"Kerala", float64(2)}, {"Goa", float64(4)}}
+	if !reflect.DeepEqual(data.Entries, want) {
+		t.Fatalf("got entries %v, want %v", data.Entries, want)
+	}
+
+	// The download form names the page of the catalog of the dataset
+	data, err = r.FetchData(context.Background(), &datagovin.Dataset{DID: 301, CatID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data.Entries, [][]interface{}{{"Assam"}}) {
+		t.Fatalf("got entries %v", data.Entries)
+	}
+
+	// The portal issues no token for unknown nodes or from other catalogs
+	for _, d := range []*datagovin.Dataset{{DID: 999, CatID: 1}, {DID: 301, CatID: 1}} {
+		_, err = r.FetchData(context.Background(), d)
+		if !errors.Is(err, ErrNoToken) {
+			t.Fatalf("got %v, want %v", err, ErrNoToken)
+		}
+	}
+}
+
+// fetched is what a run lists and fetches
+type fetched struct {
+	catalogs []uint64
+	datasets []uint64
+	data     *datagovin.Data
+}
+
+// TestRecordReplay records a run against the portal and replays it once the
+// portal is gone
+func TestRecordReplay(t *testing.T) {
+	replayed := sourcetest.RecordReplay(t, testPortal(3, 2), func(c source.Config) interface{} {
+		r := newTestRequests(t, c)
+		defer r.Stop()
+		ctx := context.Background()
+		catalogs, err := r.ListCatalogs(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		datasets, err := r.ListDatasets(ctx, catalogs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := r.FetchData(ctx, datasets[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fetched{catalogIDs(catalogs), datasetIDs(datasets), data}
+	}).(fetched)
+	if len(replayed.catalogs) != 3 || len(replayed.datasets) != 2 || len(replayed.data.Entries) != 2 {
+		t.Fatalf("unexpected run %+v", replayed)
+	}
+}
@@ -0,0 +1,60 @@
+package datagovin
+
+import (
+	"fmt"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/scripts/source"
+)
+
+// DefaultSource is the portal of profiles that do not name one
+const DefaultSource = "data.gov.in"
+
+func init() {
+	source.Register(DefaultSource, func(c source.Config) (source.Source, error) {
+		return newRequests(c)
+	})
+}
+
+// newSource returns the named source, data.gov.in when name is empty
+func newSource(name string, c source.Config) (source.Source, error) {
+	if name == "" {
+		name = DefaultSource
+	}
+	return source.New(name, c)
+}
+
+// fromSource tells whether the item was fetched from the named source
+func fromSource(itemSource, name string) bool {
+	if itemSource == "" {
+		itemSource = DefaultSource
+	}
+	if name == "" {
+		name = DefaultSource
+	}
+	return itemSource == name
+}
+
+// SourceCatalogs returns the catalogs fetched from the named source
+func SourceCatalogs(catalogs []*datagovin.Catalog, name string) []*datagovin.Catalog {
+	result := make([]*datagovin.Catalog, 0)
+	for _, c := range catalogs {
+		if fromSource(c.Source, name) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// ProfileCatalogs returns the saved catalogs of the profile
+func ProfileCatalogs(p *Profile) ([]*datagovin.Catalog, error) {
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %s", err)
+	}
+	catalogs, err := GetAllCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch catalogs: %s", err)
+	}
+	return FilterCatalogs(SourceCatalogs(catalogs, p.Source), filter), nil
+}
@@ -1,6 +1,7 @@
 package datagovin
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
@@ -11,35 +12,61 @@ import (
 	"github.com/zeu5/visualizations/models/crime"
 	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
 	"github.com/zeu5/visualizations/util"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
-func Summarise() {
-	fmt.Println("Initializing...")
-
-	err := InitializeDB(dbURL)
-	if err != nil {
+// Summarise builds the tables of the saved catalogs of the profile
+func Summarise(p *Profile) {
+	if err := summarise(context.Background(), p); err != nil {
 		log.Fatalln(err)
 	}
+}
+
+// summarise runs the summary of the topic of the profile
+func summarise(ctx context.Context, p *Profile) error {
+	summary := summaryOf(p)
+	if summary == nil {
+		return fmt.Errorf("no summary for profile %s", p.Name)
+	}
+	fmt.Println("Initializing...")
+	if err := InitializeDB(dbURL); err != nil {
+		return err
+	}
+	return summary(ctx, p)
+}
 
-	catalogs, err := GetAllCatalog()
+// summariseCrime replaces the crime tables of the datasets of the profile so
+// that it can run after every fetch
+func summariseCrime(ctx context.Context, p *Profile) error {
+	catalogs, err := ProfileCatalogs(p)
 	if err != nil {
-		log.Fatalf("could not fetch catalogs: %s", err)
+		return err
 	}
 
-	wg := util.NewCountableWaitGroup()
 	writer := uilive.New()
 	writer.Start()
 	totCatalogs := len(catalogs)
-	wg.Add(totCatalogs)
+	var pool *util.Pool
+	pool = util.NewPool(ctx, concurrency, util.OnTaskDone(func(util.TaskResult) {
+		fmt.Fprintf(writer, "Pending: %d/%d\n", pool.Pending(), totCatalogs)
+	}))
 	for _, c := range catalogs {
-		go func(cat *datagovin.Catalog) {
+		cat := c
+		pool.Submit(func(ctx context.Context) (interface{}, error) {
 			year := getCatYear(cat.Title)
 			tableEntries := make([]interface{}, 0)
+			datasetIDs := make([]uint64, 0)
 			datasets, err := GetCatalogInfo(cat)
 			if err != nil {
-				return
+				return nil, err
 			}
 			for _, d := range datasets {
+				// The tables of merged duplicates are removed as well, they
+				// hold no data of their own
+				datasetIDs = append(datasetIDs, d.DID)
+				if d.DuplicateOf != 0 {
+					continue
+				}
 				tableEntries = append(tableEntries, &crime.CrimeTable{
 					Title:     d.Title,
 					Year:      year,
@@ -47,16 +74,35 @@ func Summarise() {
 					Data:      mapData(d),
 				})
 			}
+			if len(datasetIDs) == 0 {
+				return nil, nil
+			}
 
 			coll := mgm.Coll(&crime.CrimeTable{})
-			coll.InsertMany(mgm.Ctx(), tableEntries)
-			wg.Done()
-			fmt.Fprintf(writer, "Pending: %d/%d\n", wg.Count(), totCatalogs)
-		}(c)
+			_, err = coll.DeleteMany(ctx, bson.M{"datasetid": bson.M{"$in": datasetIDs}})
+			if err != nil {
+				return nil, err
+			}
+			if len(tableEntries) == 0 {
+				return nil, nil
+			}
+			_, err = coll.InsertMany(ctx, tableEntries)
+			return nil, err
+		})
 	}
-	wg.Wait()
+	results, _ := pool.Wait()
 	writer.Stop()
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed = failed + 1
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("failed to summarise %d catalogs", failed)
+	}
 	fmt.Println("Completed!")
+	return nil
 }
 
 func mapData(d *datagovin.Dataset) crime.Data {
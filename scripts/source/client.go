@@ -0,0 +1,85 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zeu5/visualizations/util"
+	"github.com/zeu5/visualizations/util/metrics"
+)
+
+// Client describes how a source talks to its portal
+type Client struct {
+	// BaseURL is the portal, Config.BaseURL replaces it when set
+	BaseURL string
+	// Lane is the ThrottledClient lane of the requests to the host of the portal
+	Lane string
+	// IgnoreParams are left out of the keys of fixtures and cached responses,
+	// such as tokens issued per request
+	IgnoreParams []string
+	// Options are added to the ones set from the request flags
+	Options []util.ClientOptions
+}
+
+// NewClient creates the client of a source from the request flags. It
+// returns the client and the base URL of the portal requested
+func (c Config) NewClient(client Client) (*util.ThrottledClient, string, error) {
+	baseURL := strings.TrimSuffix(c.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = client.BaseURL
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base url: %s", err)
+	}
+	if c.RecordDir != "" && c.ReplayDir != "" {
+		return nil, "", errors.New("cannot record and replay fixtures at once")
+	}
+	if c.Rate <= 0 {
+		return nil, "", fmt.Errorf("rate must be positive, got %v", c.Rate)
+	}
+	// Portals often respond with 5xx and 429 or reset connections under load
+	policy := util.DefaultRetryPolicy()
+	policy.MaxAttempts = c.Retries
+	opts := []util.ClientOptions{
+		// The timeout bounds every request once it is sent, including
+		// reading the response. Zero means no timeout
+		util.WithHTTPClient(func(h *http.Client) {
+			h.Timeout = c.Timeout
+		}),
+		util.WithRetryPolicy(policy),
+		// The lane sends up to Rate requests per second, bursting up to
+		// Burst, and slows down to a tenth of Rate when the portal starts
+		// throttling
+		util.WithLane(client.Lane, util.LaneConfig{
+			Limiter: util.NewAdaptiveLimiter(c.Rate/10, c.Rate, c.Burst),
+			Hosts:   []string{base.Hostname()},
+		}),
+		util.WithObserver(metrics.DefaultObserver()),
+	}
+	opts = append(opts, client.Options...)
+	// Fixtures sit below the cache so that replays exercise it as well
+	if c.RecordDir != "" {
+		opts = append(opts, util.WithRecorder(&util.Fixtures{Dir: c.RecordDir, IgnoreParams: client.IgnoreParams}))
+	}
+	if c.ReplayDir != "" {
+		opts = append(opts, util.WithReplay(&util.Fixtures{Dir: c.ReplayDir, IgnoreParams: client.IgnoreParams}))
+	}
+	if c.CacheDir != "" {
+		cache, err := util.NewCache(util.CacheOptions{
+			Dir:          c.CacheDir,
+			MaxAge:       c.CacheMaxAge,
+			Offline:      c.Offline,
+			IgnoreParams: client.IgnoreParams,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		opts = append(opts, util.WithCache(cache))
+	}
+	return util.NewThrottledClient(200*time.Millisecond, 10, opts...), baseURL, nil
+}
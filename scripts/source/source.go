@@ -0,0 +1,122 @@
+// Package source defines the open-data portals the fetch pipeline mirrors.
+// Portals register themselves by name from an init function and profiles
+// name the one their catalogs come from
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+)
+
+// Provenance describes where a source gets its data, it is kept along with
+// dumps so that they can be cited
+type Provenance struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Publisher string `json:"publisher"`
+	License   string `json:"license"`
+}
+
+// Config holds the request flags sources are created with, a source ignores
+// the ones it does not support
+type Config struct {
+	Timeout time.Duration
+	Retries int
+	Rate    float64
+	Burst   int
+	// CacheDir enables the on-disk response cache when set
+	CacheDir    string
+	CacheMaxAge time.Duration
+	Offline     bool
+	// BaseURL points the client at another portal, such as a fake one,
+	// defaults to the portal of the source
+	BaseURL string
+	// RecordDir saves every exchange with the portal as a fixture and
+	// ReplayDir answers every request from fixtures saved there instead
+	RecordDir string
+	ReplayDir string
+	// PageSize is the number of records requested per listing page
+	PageSize int
+}
+
+// Source is an open-data portal mirrored by the fetch pipeline. Catalogs
+// group the datasets of the portal and datasets hold the tables downloaded by
+// FetchData. They are the models the pipeline saves whatever the portal,
+// sources map their own records onto them
+type Source interface {
+	// ListCatalogs returns the catalogs matching search, every catalog when
+	// it is empty
+	ListCatalogs(ctx context.Context, search string) ([]*datagovin.Catalog, error)
+	// ListCatalogsChangedSince is ListCatalogs for the catalogs changed after
+	// since, sources that can not tell list every catalog
+	ListCatalogsChangedSince(ctx context.Context, search string, since time.Time) ([]*datagovin.Catalog, error)
+	ListDatasets(ctx context.Context, c *datagovin.Catalog) ([]*datagovin.Dataset, error)
+	FetchData(ctx context.Context, d *datagovin.Dataset) (*datagovin.Data, error)
+	Provenance() Provenance
+
+	Start()
+	Stop()
+}
+
+// RequestEstimator is implemented by sources that can tell the requests a
+// fetch would send, such as for a dry run
+type RequestEstimator interface {
+	// EstimateRequests returns the requests to list the listed datasets of a
+	// catalog and fetch the data of fetched of them
+	EstimateRequests(listed, fetched int) int
+}
+
+// Factory creates a source from the request flags
+type Factory func(c Config) (Source, error)
+
+var (
+	factories = make(map[string]Factory)
+	lock      = new(sync.Mutex)
+)
+
+// Register makes the source available under name, it panics if the name is
+// already taken
+func Register(name string, f Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("source registered twice: " + name)
+	}
+	factories[name] = f
+}
+
+// Registered tells whether a source is registered under name
+func Registered(name string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// New creates the named source
+func New(name string, c Config) (Source, error) {
+	lock.Lock()
+	factory, ok := factories[name]
+	lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown source: %s", name)
+	}
+	return factory(c)
+}
+
+// Names returns the names of the registered sources in order
+func Names() []string {
+	lock.Lock()
+	defer lock.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,45 @@
+// Package sourcetest runs sources against fake portals in tests
+package sourcetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zeu5/visualizations/scripts/source"
+)
+
+// Config returns c with request flags suited to a portal on localhost, fast
+// and with a single attempt per request
+func Config(c source.Config) source.Config {
+	c.Rate = 1000
+	c.Burst = 100
+	c.Retries = 1
+	c.Timeout = 10 * time.Second
+	return c
+}
+
+// RecordReplay serves the portal and calls run with its base URL while
+// recording fixtures, then closes the portal and calls run again replaying
+// them. It fails the test unless both runs return the same and returns the
+// replayed result
+func RecordReplay(t *testing.T, portal http.Handler, run func(c source.Config) interface{}) interface{} {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv := httptest.NewServer(portal)
+	recorded := run(source.Config{BaseURL: srv.URL, RecordDir: dir})
+	srv.Close()
+	replayed := run(source.Config{BaseURL: srv.URL, ReplayDir: dir})
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Fatalf("replayed %v, recorded %v", replayed, recorded)
+	}
+	return replayed
+}
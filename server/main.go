@@ -10,6 +10,7 @@ import (
 	"github.com/zeu5/visualizations/server/config"
 	"github.com/zeu5/visualizations/server/middleware"
 	"github.com/zeu5/visualizations/server/routes"
+	"github.com/zeu5/visualizations/util/metrics"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -30,6 +31,7 @@ func Run(configPath string) {
 
 	router := gin.New()
 	router.Use(middleware.Logger)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	routes.Initialize(router)
 	fmt.Println("Starting server...")
@@ -0,0 +1,143 @@
+package datagovin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	datagovin "github.com/zeu5/visualizations/models/data.gov.in"
+	"github.com/zeu5/visualizations/server/common"
+)
+
+func datasetID(c *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Error(errors.New("bad id parameter"))
+		c.JSON(http.StatusBadRequest, common.Response{
+			Error: "invalid id parameter",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func version(c *gin.Context, s string) (int, bool) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		c.Error(errors.New("bad version parameter"))
+		c.JSON(http.StatusBadRequest, common.Response{
+			Error: "invalid version parameter",
+		})
+		return 0, false
+	}
+	return v, true
+}
+
+// Revisions lists the versions of a dataset without their data
+func Revisions(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+	revisions, err := datagovin.Revisions(id, true)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, &common.Response{
+			Error: "failed to fetch data from database",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: revisions,
+	})
+}
+
+// Revision returns a version of a dataset with its data
+func Revision(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+	v, ok := version(c, c.Param("version"))
+	if !ok {
+		return
+	}
+	revision, err := datagovin.Revision(id, v)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, &common.Response{
+			Error: "no such revision",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: revision,
+	})
+}
+
+// Diff compares the versions of a dataset given by the from and to parameters
+func Diff(c *gin.Context) {
+	id, ok := datasetID(c)
+	if !ok {
+		return
+	}
+	from, ok := version(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := version(c, c.Query("to"))
+	if !ok {
+		return
+	}
+	revisions := make([]*datagovin.DatasetRevision, 0, 2)
+	for _, v := range []int{from, to} {
+		revision, err := datagovin.Revision(id, v)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusNotFound, &common.Response{
+				Error: "no such revision",
+			})
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: datagovin.Diff(revisions[0], revisions[1]),
+	})
+}
+
+// SyncRuns lists the latest runs of the sync daemon, of the topic parameter
+// when it is given
+func SyncRuns(c *gin.Context) {
+	var limit int64 = 20
+	if limitS := c.Query("limit"); limitS != "" {
+		l, err := strconv.ParseInt(limitS, 10, 64)
+		if err != nil {
+			c.Error(errors.New("bad limit parameter"))
+			c.JSON(http.StatusBadRequest, common.Response{
+				Error: "invalid limit parameter",
+			})
+			return
+		}
+		limit = l
+	}
+	runs, err := datagovin.SyncRuns(c.Query("topic"), limit)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, &common.Response{
+			Error: "failed to fetch data from database",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: runs,
+	})
+}
+
+func Initialize(router *gin.RouterGroup) {
+	router.GET("/datasets/:id/revisions", Revisions)
+	router.GET("/datasets/:id/revisions/:version", Revision)
+	router.GET("/datasets/:id/diff", Diff)
+	router.GET("/sync/runs", SyncRuns)
+}
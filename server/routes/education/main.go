@@ -0,0 +1,93 @@
+package education
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zeu5/visualizations/models/aishe"
+	"github.com/zeu5/visualizations/server/common"
+)
+
+// query reads the year, state, districts and level parameters
+func query(c *gin.Context) (aishe.Query, bool) {
+	q := aishe.Query{
+		State:     c.Query("state"),
+		Districts: c.Query("districts") == "true",
+		Level:     c.Query("level"),
+	}
+	if yearS := c.Query("year"); yearS != "" {
+		year, err := strconv.Atoi(yearS)
+		if err != nil {
+			c.Error(errors.New("bad year parameter"))
+			c.JSON(http.StatusBadRequest, common.Response{
+				Error: "invalid year parameter",
+			})
+			return q, false
+		}
+		q.Year = year
+	}
+	return q, true
+}
+
+// Institutions lists the institution counts of the states, or of their
+// districts with districts=true
+func Institutions(c *gin.Context) {
+	q, ok := query(c)
+	if !ok {
+		return
+	}
+	institutions, err := aishe.Institutions(q)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, &common.Response{
+			Error: "failed to fetch data from database",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: institutions,
+	})
+}
+
+// Enrolments lists the enrolment counts of the states, or of their districts
+// with districts=true
+func Enrolments(c *gin.Context) {
+	q, ok := query(c)
+	if !ok {
+		return
+	}
+	enrolments, err := aishe.Enrolments(q)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, &common.Response{
+			Error: "failed to fetch data from database",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: enrolments,
+	})
+}
+
+// Years lists the survey years saved
+func Years(c *gin.Context) {
+	years, err := aishe.Years()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, &common.Response{
+			Error: "failed to fetch data from database",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &common.Response{
+		Data: years,
+	})
+}
+
+func Initialize(router *gin.RouterGroup) {
+	router.GET("/years", Years)
+	router.GET("/institutions", Institutions)
+	router.GET("/enrolments", Enrolments)
+}
@@ -3,8 +3,12 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/zeu5/visualizations/server/routes/crime"
+	"github.com/zeu5/visualizations/server/routes/datagovin"
+	"github.com/zeu5/visualizations/server/routes/education"
 )
 
 func Initialize(r *gin.Engine) {
 	crime.Initialize(r.Group("/crime"))
+	datagovin.Initialize(r.Group("/datagovin"))
+	education.Initialize(r.Group("/education"))
 }
@@ -0,0 +1,148 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ErrBodyTooLarge fails a request group when a response body is larger than
+// the configured maximum
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// BufferOptions controls how the response bodies of request group steps are
+// buffered before they are passed to the group
+type BufferOptions struct {
+	// MaxSize is the largest body accepted, 0 accepts any size
+	MaxSize int64
+	// SpoolThreshold is the size above which bodies are written to a file in
+	// SpoolDir instead of kept in memory, 0 keeps every body in memory
+	SpoolThreshold int64
+	// SpoolDir defaults to the temporary directory of the system
+	SpoolDir string
+}
+
+// WithBuffering sets how the bodies of request group responses are buffered
+func WithBuffering(o BufferOptions) ClientOptions {
+	return func(n *ThrottledClient) {
+		n.buffering = o
+	}
+}
+
+// buffered is a response body read into memory or spooled to a file
+type buffered struct {
+	data []byte
+	path string
+	size int64
+	once *sync.Once
+}
+
+// buffer reads and closes the body of resp
+func (o BufferOptions) buffer(resp *http.Response) (*buffered, error) {
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if o.MaxSize > 0 {
+		body = io.LimitReader(body, o.MaxSize+1)
+	}
+	b := &buffered{once: new(sync.Once)}
+
+	var head []byte
+	var err error
+	if o.SpoolThreshold > 0 {
+		head, err = io.ReadAll(io.LimitReader(body, o.SpoolThreshold+1))
+	} else {
+		head, err = io.ReadAll(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.size = int64(len(head))
+	if o.SpoolThreshold == 0 || b.size <= o.SpoolThreshold {
+		if o.MaxSize > 0 && b.size > o.MaxSize {
+			return nil, ErrBodyTooLarge
+		}
+		b.data = head
+		return b, nil
+	}
+
+	file, err := os.CreateTemp(o.SpoolDir, "response-*")
+	if err != nil {
+		return nil, err
+	}
+	b.path = file.Name()
+	size, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), body))
+	file.Close()
+	b.size = size
+	if err == nil && o.MaxSize > 0 && b.size > o.MaxSize {
+		err = ErrBodyTooLarge
+	}
+	if err != nil {
+		b.remove()
+		return nil, err
+	}
+	return b, nil
+}
+
+// reader returns an independent reader over the body. Closing it does not
+// remove the spool file
+func (b *buffered) reader() (io.ReadCloser, error) {
+	if b.path == "" {
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+	return os.Open(b.path)
+}
+
+// body returns a reader over the body that removes the spool file on Close
+func (b *buffered) body() (io.ReadCloser, error) {
+	r, err := b.reader()
+	if err != nil {
+		return nil, err
+	}
+	return &spooledBody{ReadCloser: r, buffered: b}, nil
+}
+
+func (b *buffered) remove() {
+	if b.path != "" {
+		b.once.Do(func() {
+			os.Remove(b.path)
+		})
+	}
+}
+
+type spooledBody struct {
+	io.ReadCloser
+	buffered *buffered
+}
+
+func (s *spooledBody) Close() error {
+	err := s.ReadCloser.Close()
+	s.buffered.remove()
+	return err
+}
+
+// bufferStep buffers the body of a request group step. resp keeps a body
+// owned by the caller and step is a copy with its own reader for the group
+func (o BufferOptions) bufferStep(resp *http.Response) (step *http.Response, err error) {
+	b, err := o.buffer(resp)
+	if err != nil {
+		return nil, err
+	}
+	body, err := b.body()
+	if err != nil {
+		b.remove()
+		return nil, err
+	}
+	stepBody, err := b.reader()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	resp.Body = body
+	resp.ContentLength = b.size
+	copied := *resp
+	copied.Body = stepBody
+	return &copied, nil
+}
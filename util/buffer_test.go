@@ -0,0 +1,150 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func spoolDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func spooled(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func textResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func readAll(t *testing.T, r io.ReadCloser) string {
+	t.Helper()
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestBufferStep(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      BufferOptions
+		body      string
+		spooled   bool
+		wantError error
+	}{
+		{"in memory", BufferOptions{}, "0123456789", false, nil},
+		{"below the threshold", BufferOptions{SpoolThreshold: 10}, "0123456789", false, nil},
+		{"above the threshold", BufferOptions{SpoolThreshold: 4}, "0123456789", true, nil},
+		{"at MaxSize", BufferOptions{MaxSize: 10, SpoolThreshold: 4}, "0123456789", true, nil},
+		{"too large in memory", BufferOptions{MaxSize: 9}, "0123456789", false, ErrBodyTooLarge},
+		{"too large spooled", BufferOptions{MaxSize: 9, SpoolThreshold: 4}, "0123456789", false, ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := spoolDir(t)
+			defer cleanup()
+			tt.opts.SpoolDir = dir
+			resp := textResponse(tt.body)
+			step, err := tt.opts.bufferStep(resp)
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("got %v, want %v", err, tt.wantError)
+			}
+			if err != nil {
+				if n := spooled(t, dir); n != 0 {
+					t.Fatalf("%d spool files left after an error", n)
+				}
+				return
+			}
+			if n := spooled(t, dir); (n == 1) != tt.spooled {
+				t.Fatalf("got %d spool files", n)
+			}
+			if resp.ContentLength != int64(len(tt.body)) {
+				t.Fatalf("got content length %d", resp.ContentLength)
+			}
+			// The group reads its own copy first, which keeps the spool file
+			if got := readAll(t, step.Body); got != tt.body {
+				t.Fatalf("step body %q", got)
+			}
+			if n := spooled(t, dir); (n == 1) != tt.spooled {
+				t.Fatalf("closing the step body removed the spool file")
+			}
+			if got := readAll(t, resp.Body); got != tt.body {
+				t.Fatalf("response body %q", got)
+			}
+			if n := spooled(t, dir); n != 0 {
+				t.Fatalf("%d spool files left once the response was closed", n)
+			}
+			// Closing again is harmless
+			resp.Body.Close()
+		})
+	}
+}
+
+// TestBufferGroupCleanup spools the responses of a request group and removes
+// the files once the caller closes the responses, or right away when the
+// group fails
+func TestBufferGroupCleanup(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	dir, cleanup := spoolDir(t)
+	defer cleanup()
+
+	group := func(maxSize int64) ([]*http.Response, error) {
+		n := NewThrottledClient(time.Millisecond, 0, WithBuffering(BufferOptions{
+			MaxSize:        maxSize,
+			SpoolThreshold: 10,
+			SpoolDir:       dir,
+		}))
+		n.Start()
+		defer n.Stop()
+		return n.DoGroup(&twoSteps{url: srv.URL}).WaitAll(context.Background())
+	}
+
+	responses, err := group(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := spooled(t, dir); n != 2 {
+		t.Fatalf("got %d spool files, want 2", n)
+	}
+	for _, resp := range responses {
+		if got := readAll(t, resp.Body); got != body {
+			t.Fatalf("got body of %d bytes", len(got))
+		}
+	}
+	if n := spooled(t, dir); n != 0 {
+		t.Fatalf("%d spool files left once the responses were closed", n)
+	}
+
+	_, err = group(50)
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("got %v, want %v", err, ErrBodyTooLarge)
+	}
+	if n := spooled(t, dir); n != 0 {
+		t.Fatalf("%d spool files left by a failed group", n)
+	}
+}
@@ -0,0 +1,293 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrNotCached is returned by an offline cache for requests it has not stored
+var ErrNotCached = errors.New("response not in cache")
+
+// CacheOptions configures the on-disk response cache
+type CacheOptions struct {
+	// Dir holds the cache, it is created when missing
+	Dir string
+	// MaxAge serves stored responses younger than it without contacting the
+	// server. Older responses are revalidated with ETag and Last-Modified
+	MaxAge time.Duration
+	// Offline serves every request from the cache and never contacts the
+	// server. Requests other than GET are only served from the cache offline,
+	// online they always reach the server
+	Offline bool
+	// IgnoreParams are query parameters left out of the cache key, such as
+	// tokens that change on every request
+	IgnoreParams []string
+}
+
+// Cache is an http.RoundTripper caching successful responses on disk, keyed
+// on method, URL and body. Bodies are stored once per content hash under
+// objects/ and an index entry per request under index/ points at them
+type Cache struct {
+	opts      CacheOptions
+	transport http.RoundTripper
+}
+
+// cacheEntry is the index entry of a cached response
+type cacheEntry struct {
+	URL          string      `json:"url"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Object       string      `json:"object"`
+	Size         int64       `json:"size"`
+	StoredAt     time.Time   `json:"stored_at"`
+}
+
+func NewCache(opts CacheOptions) (*Cache, error) {
+	for _, d := range []string{"index", "objects"} {
+		if err := os.MkdirAll(filepath.Join(opts.Dir, d), os.ModePerm); err != nil {
+			return nil, fmt.Errorf("could not create cache dir: %s", err)
+		}
+	}
+	return &Cache{opts: opts}, nil
+}
+
+// Expire marks every stored response as stale so that it is revalidated on
+// its next request even within MaxAge. Bodies and validators are kept, an
+// unchanged response then costs a 304
+func (c *Cache) Expire() error {
+	indexes, err := filepath.Glob(filepath.Join(c.opts.Dir, "index", "*.json"))
+	if err != nil {
+		return fmt.Errorf("could not list cache: %s", err)
+	}
+	for _, path := range indexes {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read cache entry: %s", err)
+		}
+		entry := new(cacheEntry)
+		if err := json.Unmarshal(contents, entry); err != nil {
+			continue
+		}
+		entry.StoredAt = time.Time{}
+		contents, err = json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			return fmt.Errorf("could not write cache entry: %s", err)
+		}
+	}
+	return nil
+}
+
+// WithCache serves requests of the client through the cache
+func WithCache(c *Cache) ClientOptions {
+	return func(n *ThrottledClient) {
+		c.transport = n.httpClient.Transport
+		if c.transport == nil {
+			c.transport = http.DefaultTransport
+		}
+		n.httpClient.Transport = c
+	}
+}
+
+// key identifies the request by method, URL and body, as Fixtures does
+func (c *Cache) key(req *http.Request, body []byte) string {
+	return requestKey(req.Method, req.URL, c.opts.IgnoreParams, body)
+}
+
+func (c *Cache) indexPath(key string) string {
+	return filepath.Join(c.opts.Dir, "index", key+".json")
+}
+
+func (c *Cache) objectPath(object string) string {
+	return filepath.Join(c.opts.Dir, "objects", object[:2], object)
+}
+
+func (c *Cache) load(key string) (*cacheEntry, bool) {
+	contents, err := os.ReadFile(c.indexPath(key))
+	if err != nil {
+		return nil, false
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(contents, entry); err != nil {
+		return nil, false
+	}
+	// A damaged entry is a miss, the object must be a content hash
+	if len(entry.Object) != 2*sha256.Size {
+		return nil, false
+	}
+	if _, err := hex.DecodeString(entry.Object); err != nil {
+		return nil, false
+	}
+	if _, err := os.Stat(c.objectPath(entry.Object)); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// respond builds a response from the cache entry
+func (c *Cache) respond(req *http.Request, entry *cacheEntry) (*http.Response, error) {
+	file, err := os.Open(c.objectPath(entry.Object))
+	if err != nil {
+		return nil, err
+	}
+	header := entry.Header.Clone()
+	header.Set("X-Cache", "HIT")
+	return &http.Response{
+		Status:        strconv.Itoa(entry.Status) + " " + http.StatusText(entry.Status),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          file,
+		ContentLength: entry.Size,
+		Request:       req,
+	}, nil
+}
+
+// store writes the body to the object store and indexes the response. The
+// returned response reads the body back from the store
+func (c *Cache) store(key string, req *http.Request, resp *http.Response) (*http.Response, error) {
+	defer resp.Body.Close()
+	tmp, err := os.CreateTemp(filepath.Join(c.opts.Dir, "objects"), "tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	entry := &cacheEntry{
+		URL:          req.URL.String(),
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Object:       hex.EncodeToString(hash.Sum(nil)),
+		Size:         size,
+		StoredAt:     time.Now(),
+	}
+	object := c.objectPath(entry.Object)
+	if err := os.MkdirAll(filepath.Dir(object), os.ModePerm); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	// Identical bodies are stored once
+	if err := os.Rename(tmp.Name(), object); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(c.indexPath(key), contents, 0644); err != nil {
+		return nil, err
+	}
+	cached, err := c.respond(req, entry)
+	if err != nil {
+		return nil, err
+	}
+	cached.Header.Set("X-Cache", "MISS")
+	return cached, nil
+}
+
+// touch marks a revalidated entry as fresh
+func (c *Cache) touch(key string, entry *cacheEntry) {
+	entry.StoredAt = time.Now()
+	if contents, err := json.Marshal(entry); err == nil {
+		os.WriteFile(c.indexPath(key), contents, 0644)
+	}
+}
+
+func (c *Cache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Cache-Control") == "no-store" {
+		if c.opts.Offline {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, fmt.Errorf("%w: %s %s", ErrNotCached, req.Method, req.URL)
+		}
+		return c.transport.RoundTrip(req)
+	}
+	if req.Method != http.MethodGet {
+		return c.roundTripUnsafe(req)
+	}
+	key := c.key(req, nil)
+	entry, ok := c.load(key)
+	if c.opts.Offline {
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotCached, req.URL)
+		}
+		return c.respond(req, entry)
+	}
+	if ok && c.opts.MaxAge > 0 && time.Since(entry.StoredAt) < c.opts.MaxAge {
+		return c.respond(req, entry)
+	}
+
+	outgoing := req
+	if ok && (entry.ETag != "" || entry.LastModified != "") {
+		outgoing = req.Clone(req.Context())
+		if entry.ETag != "" {
+			outgoing.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			outgoing.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	resp, err := c.transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		c.touch(key, entry)
+		return c.respond(req, entry)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	return c.store(key, req, resp)
+}
+
+// roundTripUnsafe always sends requests other than GET to the server, such as
+// the POST issuing a download token, and only answers them from the cache
+// when offline. Their latest successful response is stored for that purpose
+func (c *Cache) roundTripUnsafe(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := c.key(req, body)
+	if c.opts.Offline {
+		entry, ok := c.load(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s", ErrNotCached, req.Method, req.URL)
+		}
+		return c.respond(req, entry)
+	}
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	return c.store(key, req, resp)
+}
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cacheClient(t *testing.T, dir string, offline bool) *http.Client {
+	t.Helper()
+	cache, err := NewCache(CacheOptions{Dir: dir, Offline: offline, IgnoreParams: []string{"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.transport = http.DefaultTransport
+	return &http.Client{Transport: cache}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+// TestCacheReplaysPostOffline follows the token then export flow of a
+// download, which must replay offline once it was fetched online
+func TestCacheReplaysPostOffline(t *testing.T) {
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			r.ParseForm()
+			w.Write([]byte("token-" + r.PostFormValue("node")))
+			return
+		}
+		w.Write([]byte("export " + r.URL.Path))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	download := func(client *http.Client, node string) (string, error) {
+		resp, err := client.Post(srv.URL+"/token", "application/x-www-form-urlencoded", strings.NewReader("node="+node))
+		if err != nil {
+			return "", err
+		}
+		token := readBody(t, resp)
+		resp, err = client.Get(srv.URL + "/export/" + node + "?token=" + token)
+		if err != nil {
+			return "", err
+		}
+		return readBody(t, resp), nil
+	}
+
+	online := cacheClient(t, dir, false)
+	for i := 0; i < 2; i++ {
+		if _, err := download(online, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if posts != 2 {
+		t.Fatalf("online POSTs must reach the server, got %d of 2", posts)
+	}
+
+	offline := cacheClient(t, dir, true)
+	export, err := download(offline, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export != "export /export/1" {
+		t.Fatalf("got %q", export)
+	}
+	if posts != 2 {
+		t.Fatalf("offline POSTs must not reach the server, got %d", posts)
+	}
+	// The body is part of the key
+	if _, err := download(offline, "2"); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("got %v, want %v", err, ErrNotCached)
+	}
+}
+
+func TestCacheExpireRevalidates(t *testing.T) {
+	requests, revalidated := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("listing"))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(CacheOptions{Dir: dir, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.transport = http.DefaultTransport
+	client := &http.Client{Transport: cache}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+	}
+	if requests != 1 {
+		t.Fatalf("responses within MaxAge must be served from the cache, got %d requests", requests)
+	}
+	if err := cache.Expire(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readBody(t, resp); got != "listing" {
+		t.Fatalf("got %q", got)
+	}
+	if requests != 2 || revalidated != 1 {
+		t.Fatalf("expired responses must be revalidated, got %d requests and %d revalidations", requests, revalidated)
+	}
+}
+
+// TestCacheDamagedEntry treats an index entry pointing at something other
+// than a stored object as a miss
+func TestCacheDamagedEntry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("listing"))
+	}))
+	defer srv.Close()
+	objects := []string{"", "a", "../../index", strings.Repeat("z", 64)}
+	for _, object := range objects {
+		t.Run(object, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			resp, err := cacheClient(t, dir, false).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBody(t, resp)
+
+			indexes, err := filepath.Glob(filepath.Join(dir, "index", "*.json"))
+			if err != nil || len(indexes) != 1 {
+				t.Fatalf("got index %v, %v", indexes, err)
+			}
+			entry := fmt.Sprintf(`{"url":%q,"status":200,"object":%q}`, srv.URL, object)
+			if err := os.WriteFile(indexes[0], []byte(entry), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cacheClient(t, dir, true).Get(srv.URL); !errors.Is(err, ErrNotCached) {
+				t.Fatalf("got %v, want %v", err, ErrNotCached)
+			}
+			resp, err = cacheClient(t, dir, false).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readBody(t, resp); got != "listing" {
+				t.Fatalf("got %q", got)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cache := &Cache{opts: CacheOptions{IgnoreParams: []string{"token"}}}
+	fixtures := &Fixtures{IgnoreParams: []string{"token"}}
+	tests := []struct {
+		method, url string
+		body        string
+	}{
+		{"GET", "https://data.gov.in/node?b=2&a=1", ""},
+		{"GET", "https://data.gov.in/export?token=abc&format=csv", ""},
+		{"POST", "https://data.gov.in/token", "node=1"},
+	}
+	for _, tt := range tests {
+		req := get(t, tt.url)
+		req.Method = tt.method
+		want, err := fixtures.key(tt.method, tt.url, []byte(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cache.key(req, []byte(tt.body)); got != want {
+			t.Fatalf("%s %s: cache and fixtures keys differ", tt.method, tt.url)
+		}
+	}
+	withToken, _ := fixtures.key("GET", "https://data.gov.in/export?format=csv&token=abc", nil)
+	otherToken, _ := fixtures.key("GET", "https://data.gov.in/export?token=def&format=csv", nil)
+	if withToken != otherToken {
+		t.Fatal("ignored parameters changed the key")
+	}
+}
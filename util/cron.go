@@ -0,0 +1,251 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression of five fields, minute hour day-of-month
+// month day-of-week. Fields take *, values, ranges a-b, lists a,b and steps
+// */n or a-b/n, months and weekdays also take their three letter names. As
+// in cron a day matches either day field when both are restricted
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dowNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type scheduleField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	// 7 is accepted for sunday as well
+	{name: "day of week", min: 0, max: 7, names: dowNames},
+}
+
+// ParseSchedule parses a cron expression or one of @yearly, @monthly,
+// @weekly, @daily and @hourly
+func ParseSchedule(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := scheduleDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected %d fields", expr, len(scheduleFields))
+	}
+	bits := make([]uint64, len(parts))
+	for i, part := range parts {
+		b, err := scheduleFields[i].parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", expr, err)
+		}
+		bits[i] = b
+	}
+	// Sunday is 0
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	s := &Schedule{
+		expr:   expr,
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: strings.HasPrefix(parts[2], "*") || parts[2] == "?",
+		anyDow: strings.HasPrefix(parts[4], "*") || parts[4] == "?",
+	}
+	if !s.occurs() {
+		return nil, fmt.Errorf("invalid schedule %q: no month has the day of month", expr)
+	}
+	return s, nil
+}
+
+// monthDays are the most days of every month
+var monthDays = []int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// occurs tells whether any date matches the schedule. Only a day of month
+// restricted without a day of week can miss every month, such as the 31st
+// of February
+func (s *Schedule) occurs() bool {
+	if s.anyDom || !s.anyDow {
+		return true
+	}
+	for m := 1; m <= 12; m++ {
+		if s.month&(1<<uint(m)) == 0 {
+			continue
+		}
+		for d := 1; d <= monthDays[m]; d++ {
+			if s.dom&(1<<uint(d)) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f scheduleField) value(s string) (int, error) {
+	for i, n := range f.names {
+		if n != "" && strings.EqualFold(s, n) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (f scheduleField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rangePart = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+		}
+		start, end := f.min, f.max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if end, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			v, err := f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			start = v
+			// a/n steps from a to the end of the field
+			if step == 1 {
+				end = v
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t matching the schedule, in the location
+// of t. It returns the zero time when nothing matches within five years.
+// Times skipped by a daylight saving change run once the clock is as far
+// past the change, times repeated by one run the first time only
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// The wall clock of t is searched in UTC, which has no daylight saving
+	// changes, and the match placed back in loc
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	limit := wall.AddDate(5, 0, 0)
+	for {
+		wall = s.next(wall, limit)
+		if wall.IsZero() {
+			return time.Time{}
+		}
+		next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+		// Date leaves the choice open for skipped and repeated times. Both
+		// take the offset in effect before the change, which places a
+		// repeated time at its first occurrence, before t when t is in its
+		// second one
+		_, offset := next.Add(-12 * time.Hour).Zone()
+		before := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if sameWallClock(before, wall) || !sameWallClock(next, wall) {
+			next = before
+		}
+		if next.After(t) {
+			return next
+		}
+	}
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	return t.Year() == wall.Year() && t.YearDay() == wall.YearDay() &&
+		t.Hour() == wall.Hour() && t.Minute() == wall.Minute()
+}
+
+// next returns the first wall clock time after wall matching the schedule,
+// or the zero time when none does before limit
+func (s *Schedule) next(wall, limit time.Time) time.Time {
+	t := wall.Add(time.Minute)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
@@ -0,0 +1,168 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+// runs returns the next len(want) times of the schedule after from
+func runs(t *testing.T, expr string, from time.Time, n int) []time.Time {
+	t.Helper()
+	s, err := ParseSchedule(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]time.Time, n)
+	for i := range result {
+		from = s.Next(from)
+		result[i] = from
+	}
+	return result
+}
+
+func checkRuns(t *testing.T, got, want []time.Time) {
+	t.Helper()
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Fatalf("run %d at %s, want %s", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		// Steps, ranges and lists
+		{"*/15 * * * *", utc(2021, 3, 1, 10, 7), []time.Time{utc(2021, 3, 1, 10, 15), utc(2021, 3, 1, 10, 30), utc(2021, 3, 1, 10, 45), utc(2021, 3, 1, 11, 0)}},
+		{"5/20 * * * *", utc(2021, 3, 1, 10, 7), []time.Time{utc(2021, 3, 1, 10, 25), utc(2021, 3, 1, 10, 45), utc(2021, 3, 1, 11, 5)}},
+		{"0 9-17/4 * * *", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 3, 1, 9, 0), utc(2021, 3, 1, 13, 0), utc(2021, 3, 1, 17, 0), utc(2021, 3, 2, 9, 0)}},
+		{"5,10 0 * * *", utc(2021, 3, 1, 0, 5), []time.Time{utc(2021, 3, 1, 0, 10), utc(2021, 3, 2, 0, 5)}},
+		{"0,30 1-2,23 * * *", utc(2021, 3, 1, 2, 0), []time.Time{utc(2021, 3, 1, 2, 30), utc(2021, 3, 1, 23, 0), utc(2021, 3, 1, 23, 30), utc(2021, 3, 2, 1, 0)}},
+		// Seconds are dropped, the next run is after the current minute
+		{"* * * * *", utc(2021, 3, 1, 10, 7).Add(30 * time.Second), []time.Time{utc(2021, 3, 1, 10, 8)}},
+
+		// Month and day names
+		{"0 0 1 jan,Jul *", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 7, 1, 0, 0), utc(2022, 1, 1, 0, 0)}},
+		{"0 0 1 FEB-apr/2 *", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 4, 1, 0, 0), utc(2022, 2, 1, 0, 0)}},
+		{"0 0 * * mon-fri", utc(2021, 3, 5, 12, 0), []time.Time{utc(2021, 3, 8, 0, 0), utc(2021, 3, 9, 0, 0)}},
+		{"0 0 * * SUN", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 3, 7, 0, 0), utc(2021, 3, 14, 0, 0)}},
+		{"0 0 * * 7", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 3, 7, 0, 0), utc(2021, 3, 14, 0, 0)}},
+		{"0 0 * * 5-7", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 3, 5, 0, 0), utc(2021, 3, 6, 0, 0), utc(2021, 3, 7, 0, 0), utc(2021, 3, 12, 0, 0)}},
+
+		// Both day fields restricted match either, one of them * matches both
+		{"0 12 1-7 * mon", utc(2021, 3, 6, 13, 0), []time.Time{utc(2021, 3, 7, 12, 0), utc(2021, 3, 8, 12, 0), utc(2021, 3, 15, 12, 0)}},
+		{"0 12 13 * fri", utc(2021, 3, 1, 0, 0), []time.Time{utc(2021, 3, 5, 12, 0), utc(2021, 3, 12, 12, 0), utc(2021, 3, 13, 12, 0), utc(2021, 3, 19, 12, 0)}},
+		{"0 12 */10 * mon", utc(2021, 3, 2, 0, 0), []time.Time{utc(2021, 5, 31, 12, 0), utc(2021, 6, 21, 12, 0), utc(2021, 10, 11, 12, 0)}},
+		{"0 12 ? * mon", utc(2021, 3, 2, 0, 0), []time.Time{utc(2021, 3, 8, 12, 0)}},
+		{"0 0 31 2 mon", utc(2021, 3, 2, 0, 0), []time.Time{utc(2022, 2, 7, 0, 0), utc(2022, 2, 14, 0, 0)}},
+
+		// Days only some months have
+		{"0 0 31 * *", utc(2021, 4, 1, 0, 0), []time.Time{utc(2021, 5, 31, 0, 0), utc(2021, 7, 31, 0, 0)}},
+		{"0 0 29 2 *", utc(2021, 1, 1, 0, 0), []time.Time{utc(2024, 2, 29, 0, 0), utc(2028, 2, 29, 0, 0)}},
+
+		// Descriptors
+		{"@hourly", utc(2021, 3, 1, 10, 7), []time.Time{utc(2021, 3, 1, 11, 0)}},
+		{"@weekly", utc(2021, 3, 1, 10, 7), []time.Time{utc(2021, 3, 7, 0, 0)}},
+		{"@yearly", utc(2021, 3, 1, 10, 7), []time.Time{utc(2022, 1, 1, 0, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			checkRuns(t, runs(t, tt.expr, tt.from, len(tt.want)), tt.want)
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "expected 5 fields"},
+		{"60 * * * *", "minute 60 out of range 0-59"},
+		{"0 24 * * *", "hour 24 out of range 0-23"},
+		{"0 0 0 * *", "day of month 0 out of range 1-31"},
+		{"0 0 * 13 *", "month 13 out of range 1-12"},
+		{"0 0 * * 8", "day of week 8 out of range 0-7"},
+		{"*/0 * * * *", "invalid step"},
+		{"5-1 * * * *", "invalid range"},
+		{"0 0 * foo *", `invalid month "foo"`},
+		{"@sometimes", "expected 5 fields"},
+		// Schedules that never occur
+		{"0 0 31 2 *", "no month has the day of month"},
+		{"0 0 30,31 feb *", "no month has the day of month"},
+		{"0 0 31 4,6,9,11 *", "no month has the day of month"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseSchedule(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestScheduleNextStops returns the zero time instead of searching on for a
+// date too far away
+func TestScheduleNextStops(t *testing.T) {
+	s, err := ParseSchedule("0 0 29 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2100 is not a leap year
+	if next := s.Next(utc(2097, 3, 1, 0, 0)); !next.IsZero() {
+		t.Fatalf("got %s, want the zero time", next)
+	}
+}
+
+func TestScheduleNextDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2021, month, day, hour, min, 0, 0, ny)
+	}
+	// On 2021-03-14 the clock goes from 01:59 EST to 03:00 EDT, on
+	// 2021-11-07 from 01:59 EDT back to 01:00 EST
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"skipped time runs as far past the gap", "30 2 * * *", local(3, 13, 3, 0),
+			[]time.Time{local(3, 13, 3, 0).Add(23*time.Hour + 30*time.Minute), local(3, 15, 2, 30)}},
+		{"hours across the gap", "0 * * * *", local(3, 14, 0, 30),
+			[]time.Time{local(3, 14, 1, 0), local(3, 14, 3, 0), local(3, 14, 4, 0)}},
+		{"half hours across the gap", "*/30 * * * *", local(3, 14, 1, 0),
+			[]time.Time{local(3, 14, 1, 30), local(3, 14, 3, 0), local(3, 14, 3, 30), local(3, 14, 4, 0)}},
+		{"repeated time runs once", "30 1 * * *", local(11, 6, 12, 0),
+			[]time.Time{utc(2021, 11, 7, 5, 30), utc(2021, 11, 8, 6, 30)}},
+		{"hours across the overlap", "0 * * * *", utc(2021, 11, 7, 4, 30).In(ny),
+			[]time.Time{utc(2021, 11, 7, 5, 0), utc(2021, 11, 7, 7, 0), utc(2021, 11, 7, 8, 0)}},
+		{"from the repeated hour", "30 1 * * *", utc(2021, 11, 7, 6, 10).In(ny),
+			[]time.Time{utc(2021, 11, 8, 6, 30)}},
+		{"days across both changes", "0 12 * * *", local(3, 13, 12, 0),
+			[]time.Time{local(3, 14, 12, 0), local(3, 15, 12, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runs(t, tt.expr, tt.from, len(tt.want))
+			checkRuns(t, got, tt.want)
+			for i, next := range got {
+				if next.Location() != ny {
+					t.Fatalf("run %d in %s", i+1, next.Location())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ErrNoFixture is returned when replaying a request that was not recorded
+var ErrNoFixture = errors.New("no fixture recorded for request")
+
+// Fixture is a recorded request and its response
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+type FixtureRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type FixtureResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	// Body is kept as text when it is valid UTF-8 and base64 encoded otherwise
+	Body       string `json:"body,omitempty"`
+	BodyBase64 bool   `json:"body_base64,omitempty"`
+}
+
+// Fixtures stores request/response pairs as one json file per request in a
+// directory. Requests are matched on method, URL and body, leaving out the
+// query parameters in IgnoreParams
+type Fixtures struct {
+	Dir          string
+	IgnoreParams []string
+}
+
+func (f *Fixtures) key(method, rawURL string, body []byte) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return requestKey(method, u, f.IgnoreParams, body), nil
+}
+
+// requestKey identifies a request by method, URL and body, leaving out the
+// query parameters in ignore. Fixtures and the cache name their files by it
+func requestKey(method string, u *url.URL, ignore []string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + stripParams(u, ignore).String() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// stripParams returns a copy of u without the query parameters in params
+func stripParams(u *url.URL, params []string) *url.URL {
+	stripped := *u
+	query := stripped.Query()
+	for _, p := range params {
+		query.Del(p)
+	}
+	stripped.RawQuery = query.Encode()
+	return &stripped
+}
+
+func (f *Fixtures) path(key string) string {
+	return filepath.Join(f.Dir, key+".json")
+}
+
+// Save writes the fixture, replacing an earlier recording of the request
+func (f *Fixtures) Save(fixture *Fixture) error {
+	key, err := f.key(fixture.Request.Method, fixture.Request.URL, []byte(fixture.Request.Body))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, os.ModePerm); err != nil {
+		return fmt.Errorf("could not create fixture dir: %s", err)
+	}
+	contents, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path(key), contents, 0644)
+}
+
+// Load returns the fixture recorded for the request
+func (f *Fixtures) Load(method, rawURL string, body []byte) (*Fixture, error) {
+	key, err := f.key(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoFixture, method, rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fixture := new(Fixture)
+	if err := json.Unmarshal(contents, fixture); err != nil {
+		return nil, fmt.Errorf("could not parse fixture: %s", err)
+	}
+	return fixture, nil
+}
+
+// readRequestBody reads the body of req and replaces it with a copy
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recorder is an http.RoundTripper saving every exchange as a fixture
+type recorder struct {
+	fixtures  *Fixtures
+	transport http.RoundTripper
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// Ignored parameters such as tokens and api keys are not written to disk
+	fixture := &Fixture{
+		Request: FixtureRequest{
+			Method: req.Method,
+			URL:    stripParams(req.URL, r.fixtures.IgnoreParams).String(),
+			Body:   string(reqBody),
+		},
+		Response: FixtureResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header,
+		},
+	}
+	if utf8.Valid(respBody) {
+		fixture.Response.Body = string(respBody)
+	} else {
+		fixture.Response.Body = base64.StdEncoding.EncodeToString(respBody)
+		fixture.Response.BodyBase64 = true
+	}
+	if err := r.fixtures.Save(fixture); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not record fixture: %s", err)
+	}
+	return resp, nil
+}
+
+// replayer is an http.RoundTripper answering from fixtures only
+type replayer struct {
+	fixtures *Fixtures
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	fixture, err := r.fixtures.Load(req.Method, req.URL.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	body := []byte(fixture.Response.Body)
+	if fixture.Response.BodyBase64 {
+		body, err = base64.StdEncoding.DecodeString(fixture.Response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode fixture body: %s", err)
+		}
+	}
+	header := fixture.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(fixture.Response.Status) + " " + http.StatusText(fixture.Response.Status),
+		StatusCode:    fixture.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// WithRecorder saves every request sent by the client and its response as
+// a fixture
+func WithRecorder(f *Fixtures) ClientOptions {
+	return func(n *ThrottledClient) {
+		transport := n.httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		n.httpClient.Transport = &recorder{fixtures: f, transport: transport}
+	}
+}
+
+// WithReplay answers every request of the client from fixtures without
+// touching the network. Requests that were not recorded fail with ErrNoFixture
+func WithReplay(f *Fixtures) ClientOptions {
+	return func(n *ThrottledClient) {
+		n.httpClient.Transport = &replayer{fixtures: f}
+	}
+}
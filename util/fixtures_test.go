@@ -0,0 +1,63 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecorderStripsIgnoredParams keeps secrets passed as query parameters
+// out of the recorded fixtures, which still replay with any value of them
+func TestRecorderStripsIgnoredParams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("resource " + r.URL.Query().Get("format")))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := &Fixtures{Dir: dir, IgnoreParams: []string{"token", "api-key"}}
+
+	record := NewThrottledClient(time.Millisecond, 0, WithRecorder(fixtures))
+	record.Start()
+	resp, err := record.DoContext(context.Background(), get(t, srv.URL+"/resource?api-key=secret-key&format=json&token=secret-token")).Wait(context.Background())
+	record.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got fixtures %v, %v", files, err)
+	}
+	contents, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), "secret") {
+		t.Fatalf("recorded fixture holds an ignored parameter:\n%s", contents)
+	}
+	if !strings.Contains(string(contents), "format=json") {
+		t.Fatalf("recorded fixture lost the other parameters:\n%s", contents)
+	}
+
+	replay := NewThrottledClient(time.Millisecond, 0, WithReplay(fixtures))
+	replay.Start()
+	defer replay.Stop()
+	resp, err = replay.DoContext(context.Background(), get(t, srv.URL+"/resource?format=json&token=other&api-key=other")).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readBody(t, resp); got != "resource json" {
+		t.Fatalf("got %q", got)
+	}
+}
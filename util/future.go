@@ -0,0 +1,167 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrResultClaimed is returned when the result of a Future was already
+// handed out by Wait, WaitAll or Then
+var ErrResultClaimed = errors.New("result already claimed")
+
+// Result is the outcome of a request or a request group
+type Result struct {
+	// Response is set for requests
+	Response *http.Response
+	// Responses holds the response of every step of a request group
+	Responses []*http.Response
+	Err       error
+}
+
+// Close closes every response body of the result
+func (r *Result) Close() {
+	if r.Response != nil {
+		r.Response.Body.Close()
+	}
+	closeAll(r.Responses)
+}
+
+// Future is the pending result of a request queued on the ThrottledClient.
+// The result is handed out once, to Wait, WaitAll or Then. A result that is
+// never claimed because the caller gave up or called Discard has its response
+// bodies closed by the Future
+type Future struct {
+	done   chan struct{}
+	result Result
+	// claimed is set once the result is handed out and abandoned once the
+	// caller is no longer interested in it
+	claimed   bool
+	abandoned bool
+	lock      *sync.Mutex
+}
+
+func newFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+		lock: new(sync.Mutex),
+	}
+}
+
+// failedFuture is returned when a request could not be queued
+func failedFuture(err error) *Future {
+	f := newFuture()
+	f.complete(Result{Err: err})
+	return f
+}
+
+// complete sets the result if none is set yet. It returns false when the
+// future was already completed, the caller then owns res
+func (f *Future) complete(res Result) bool {
+	f.lock.Lock()
+	select {
+	case <-f.done:
+		f.lock.Unlock()
+		return false
+	default:
+	}
+	f.result = res
+	close(f.done)
+	abandoned := f.abandoned
+	f.lock.Unlock()
+	if abandoned {
+		res.Close()
+	}
+	return true
+}
+
+func (f *Future) completed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done is closed once the result is available
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// claim hands out the result, it must only be called once done is closed
+func (f *Future) claim() (Result, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.claimed || f.abandoned {
+		return Result{}, ErrResultClaimed
+	}
+	f.claimed = true
+	return f.result, nil
+}
+
+// abandon closes the result now if it is available or as soon as it arrives
+func (f *Future) abandon() {
+	f.lock.Lock()
+	if f.claimed || f.abandoned {
+		f.lock.Unlock()
+		return
+	}
+	f.abandoned = true
+	res := f.result
+	completed := f.completed()
+	f.lock.Unlock()
+	if completed {
+		res.Close()
+	}
+}
+
+func (f *Future) wait(ctx context.Context) (Result, error) {
+	select {
+	case <-f.done:
+		return f.claim()
+	case <-ctx.Done():
+		f.abandon()
+		return Result{}, ctx.Err()
+	}
+}
+
+// Wait blocks until the response of a request is available or ctx is done.
+// The caller must close the body of the returned response. If ctx is done
+// first the response is closed once it arrives
+func (f *Future) Wait(ctx context.Context) (*http.Response, error) {
+	res, err := f.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Response, res.Err
+}
+
+// WaitAll is Wait for request groups, returning the response of every step
+func (f *Future) WaitAll(ctx context.Context) ([]*http.Response, error) {
+	res, err := f.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Responses, res.Err
+}
+
+// Then calls cb with the result in a new goroutine once it is available.
+// Response bodies are closed when cb returns
+func (f *Future) Then(cb func(*Result)) {
+	go func() {
+		<-f.done
+		res, err := f.claim()
+		if err != nil {
+			return
+		}
+		defer res.Close()
+		cb(&res)
+	}()
+}
+
+// Discard gives up on the result, closing any response
+func (f *Future) Discard() {
+	f.abandon()
+}
@@ -0,0 +1,150 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackedBody records whether it was closed
+type trackedBody struct {
+	io.Reader
+	closed int32
+}
+
+func (b *trackedBody) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return nil
+}
+
+func (b *trackedBody) isClosed() bool {
+	return atomic.LoadInt32(&b.closed) == 1
+}
+
+func trackedResponse() (*http.Response, *trackedBody) {
+	body := &trackedBody{Reader: strings.NewReader("ok")}
+	return &http.Response{StatusCode: http.StatusOK, Body: body}, body
+}
+
+func TestFutureClaimOnce(t *testing.T) {
+	f := newFuture()
+	resp, body := trackedResponse()
+	if !f.complete(Result{Response: resp}) {
+		t.Fatal("first completion refused")
+	}
+	if f.complete(Result{Err: errors.New("late")}) {
+		t.Fatal("second completion accepted")
+	}
+	got, err := f.Wait(context.Background())
+	if err != nil || got != resp {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	if _, err := f.Wait(context.Background()); !errors.Is(err, ErrResultClaimed) {
+		t.Fatalf("second Wait returned %v", err)
+	}
+	if _, err := f.WaitAll(context.Background()); !errors.Is(err, ErrResultClaimed) {
+		t.Fatalf("WaitAll after Wait returned %v", err)
+	}
+	// A claimed result belongs to the caller, Discard leaves it open
+	f.Discard()
+	if body.isClosed() {
+		t.Fatal("Discard closed a claimed response")
+	}
+}
+
+func TestFutureWaitAll(t *testing.T) {
+	f := newFuture()
+	first, _ := trackedResponse()
+	second, _ := trackedResponse()
+	f.complete(Result{Responses: []*http.Response{first, second}})
+	responses, err := f.WaitAll(context.Background())
+	if err != nil || len(responses) != 2 || responses[0] != first || responses[1] != second {
+		t.Fatalf("got %v, %v", responses, err)
+	}
+}
+
+func TestFutureError(t *testing.T) {
+	want := errors.New("failed")
+	f := failedFuture(want)
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("failed future not done")
+	}
+	if _, err := f.Wait(context.Background()); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+}
+
+// TestFutureAbandonBeforeResult closes the response that arrives after the
+// caller gave up
+func TestFutureAbandonBeforeResult(t *testing.T) {
+	for _, giveUp := range []string{"Discard", "Wait"} {
+		t.Run(giveUp, func(t *testing.T) {
+			f := newFuture()
+			if giveUp == "Discard" {
+				f.Discard()
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				if _, err := f.Wait(ctx); !errors.Is(err, context.Canceled) {
+					t.Fatalf("Wait returned %v", err)
+				}
+			}
+			resp, body := trackedResponse()
+			f.complete(Result{Response: resp})
+			if !body.isClosed() {
+				t.Fatal("response arriving after the caller gave up was not closed")
+			}
+			if _, err := f.Wait(context.Background()); !errors.Is(err, ErrResultClaimed) {
+				t.Fatalf("Wait after giving up returned %v", err)
+			}
+		})
+	}
+}
+
+func TestFutureAbandonAfterResult(t *testing.T) {
+	f := newFuture()
+	first, firstBody := trackedResponse()
+	second, secondBody := trackedResponse()
+	f.complete(Result{Responses: []*http.Response{first, second}})
+	f.Discard()
+	if !firstBody.isClosed() || !secondBody.isClosed() {
+		t.Fatal("Discard left responses of a group open")
+	}
+}
+
+func TestFutureThen(t *testing.T) {
+	f := newFuture()
+	resp, body := trackedResponse()
+	called := make(chan string, 1)
+	f.Then(func(res *Result) {
+		contents, _ := ioutil.ReadAll(res.Response.Body)
+		called <- string(contents)
+	})
+	f.complete(Result{Response: resp})
+	select {
+	case contents := <-called:
+		if contents != "ok" {
+			t.Fatalf("got %q", contents)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Then callback not called")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !body.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("response not closed once the callback returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := f.Wait(context.Background()); !errors.Is(err, ErrResultClaimed) {
+		t.Fatalf("Wait after Then returned %v", err)
+	}
+}
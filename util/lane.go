@@ -0,0 +1,180 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLane receives the requests that are not routed to any other lane
+const DefaultLane = "default"
+
+// LaneConfig describes a lane of the ThrottledClient. Every lane has its own
+// queue, limiter and concurrency so that slow upstreams do not hold up others
+type LaneConfig struct {
+	// Limiter paces the lane, defaults to the interval of the client
+	Limiter Limiter
+	// Concurrency bounds the requests in flight, below 1 is unbounded
+	Concurrency int
+	// QueueSize bounds the queued requests, defaults to the client maxsize
+	QueueSize int
+	// Hosts routes requests to these hosts, and their subdomains, to the lane
+	Hosts []string
+}
+
+// LaneStats is a snapshot of the activity of a lane
+type LaneStats struct {
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	InFlight  int64  `json:"in_flight"`
+	Completed uint64 `json:"completed"`
+	Failed    uint64 `json:"failed"`
+}
+
+type lane struct {
+	name    string
+	limiter Limiter
+	queue   *queue
+	// slots holds a token for every request in flight, nil when unbounded
+	slots    chan struct{}
+	pollDone chan struct{}
+
+	inFlight  int64
+	completed uint64
+	failed    uint64
+}
+
+func newLane(name string, limiter Limiter, concurrency, queueSize int) *lane {
+	l := &lane{
+		name:     name,
+		limiter:  limiter,
+		queue:    newQueue(queueSize),
+		pollDone: make(chan struct{}),
+	}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// acquire blocks until the lane can send one more request
+func (l *lane) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	atomic.AddInt64(&l.inFlight, 1)
+	return nil
+}
+
+func (l *lane) release(failed bool) {
+	atomic.AddInt64(&l.inFlight, -1)
+	if failed {
+		atomic.AddUint64(&l.failed, 1)
+	} else {
+		atomic.AddUint64(&l.completed, 1)
+	}
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *lane) stats() LaneStats {
+	return LaneStats{
+		Name:      l.name,
+		Queued:    l.queue.len(),
+		InFlight:  atomic.LoadInt64(&l.inFlight),
+		Completed: atomic.LoadUint64(&l.completed),
+		Failed:    atomic.LoadUint64(&l.failed),
+	}
+}
+
+// lanes routes requests to lanes by name or by host
+type lanes struct {
+	byName map[string]*lane
+	byHost map[string]*lane
+	order  []*lane
+	lock   *sync.RWMutex
+}
+
+func newLanes() *lanes {
+	return &lanes{
+		byName: make(map[string]*lane),
+		byHost: make(map[string]*lane),
+		order:  make([]*lane, 0),
+		lock:   new(sync.RWMutex),
+	}
+}
+
+func (ls *lanes) add(l *lane, hosts []string) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if existing, ok := ls.byName[l.name]; ok {
+		for i, o := range ls.order {
+			if o == existing {
+				ls.order[i] = l
+			}
+		}
+	} else {
+		ls.order = append(ls.order, l)
+	}
+	ls.byName[l.name] = l
+	for _, h := range hosts {
+		ls.byHost[strings.ToLower(h)] = l
+	}
+}
+
+func (ls *lanes) named(name string) (*lane, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	l, ok := ls.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown lane: %s", name)
+	}
+	return l, nil
+}
+
+// route picks the lane of the closest matching host, falling back to the
+// default lane
+func (ls *lanes) route(req *http.Request) *lane {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	if req != nil && req.URL != nil {
+		host := strings.ToLower(req.URL.Hostname())
+		for host != "" {
+			if l, ok := ls.byHost[host]; ok {
+				return l
+			}
+			i := strings.Index(host, ".")
+			if i < 0 {
+				break
+			}
+			host = host[i+1:]
+		}
+	}
+	return ls.byName[DefaultLane]
+}
+
+func (ls *lanes) all() []*lane {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	all := make([]*lane, len(ls.order))
+	copy(all, ls.order)
+	return all
+}
+
+// RequestOptions configure a single request or request group
+type RequestOptions func(*request)
+
+// OnLane sends the request through the named lane instead of routing it by host
+func OnLane(name string) RequestOptions {
+	return func(r *request) {
+		r.laneName = name
+	}
+}
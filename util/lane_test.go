@@ -0,0 +1,128 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLaneRouting(t *testing.T) {
+	n := NewThrottledClient(time.Millisecond, 0,
+		WithLane("portal", LaneConfig{Hosts: []string{"data.gov.in"}}),
+		WithLane("api", LaneConfig{Hosts: []string{"API.data.gov.in"}}),
+	)
+	tests := []struct {
+		url  string
+		lane string
+	}{
+		{"https://data.gov.in/node", "portal"},
+		{"https://www.data.gov.in/node", "portal"},
+		{"https://api.data.gov.in/resource", "api"},
+		{"https://v1.API.data.gov.in/resource", "api"},
+		{"https://DATA.gov.in:8443/node", "portal"},
+		{"https://gov.in/", DefaultLane},
+		{"https://aishe.gov.in/", DefaultLane},
+		{"https://example.com/data.gov.in", DefaultLane},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := n.lanes.route(get(t, tt.url)).name; got != tt.lane {
+				t.Fatalf("routed to %s, want %s", got, tt.lane)
+			}
+		})
+	}
+}
+
+func TestOnLaneUnknown(t *testing.T) {
+	n := NewThrottledClient(time.Millisecond, 0)
+	n.Start()
+	defer n.Stop()
+	_, err := n.DoContext(context.Background(), get(t, "http://localhost"), OnLane("missing")).Wait(context.Background())
+	if err == nil {
+		t.Fatal("request on an unknown lane succeeded")
+	}
+}
+
+// TestLaneOrdering sends the requests of a lane one at a time in the order
+// they were queued
+func TestLaneOrdering(t *testing.T) {
+	var paths []string
+	lock := new(sync.Mutex)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	n := NewThrottledClient(time.Millisecond, 0, WithLane("serial", LaneConfig{Concurrency: 1}))
+
+	futures := make([]*Future, 5)
+	want := make([]string, len(futures))
+	for i := range futures {
+		want[i] = fmt.Sprintf("/%d", i)
+		futures[i] = n.DoContext(context.Background(), get(t, srv.URL+want[i]), OnLane("serial"))
+	}
+	n.Start()
+	for _, f := range futures {
+		resp, err := f.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	n.Stop()
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("sent %v, want %v", paths, want)
+	}
+}
+
+// TestLaneIsolation keeps the default lane going while a lane is stuck on a
+// slow upstream
+func TestLaneIsolation(t *testing.T) {
+	slow := newBlockingServer()
+	defer slow.Close()
+	fast := newOKServer()
+	defer fast.Close()
+	n := NewThrottledClient(time.Millisecond, 0, WithLane("slow", LaneConfig{Concurrency: 1}))
+	n.Start()
+	defer n.Stop()
+	release := new(sync.Once)
+	defer release.Do(func() { close(slow.release) })
+
+	stuck := n.DoContext(context.Background(), get(t, slow.URL), OnLane("slow"))
+	waitFor(t, slow.started)
+	queued := n.DoContext(context.Background(), get(t, slow.URL), OnLane("slow"))
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		resp, err := n.DoContext(ctx, get(t, fast.URL)).Wait(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("default lane held up by the slow lane: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	stats := make(map[string]LaneStats)
+	for _, s := range n.Stats() {
+		stats[s.Name] = s
+	}
+	if s := stats["slow"]; s.InFlight != 1 || s.Completed != 0 {
+		t.Fatalf("unexpected stats of the slow lane %+v", s)
+	}
+
+	release.Do(func() { close(slow.release) })
+	for _, f := range []*Future{stuck, queued} {
+		resp, err := f.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
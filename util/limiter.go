@@ -0,0 +1,194 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter paces the requests sent by the ThrottledClient
+type Limiter interface {
+	// Wait blocks until a request can be sent or ctx is done
+	Wait(ctx context.Context) error
+	// Observe is called with the outcome of every request that was sent
+	Observe(resp *http.Response, err error)
+}
+
+// TokenBucket allows bursts of up to burst requests and refills at rate
+// requests per second
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   *sync.Mutex
+}
+
+// NewTokenBucket panics if rate is not positive as the bucket would never
+// hold a request back
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		panic("non-positive rate for NewTokenBucket")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		lock:   new(sync.Mutex),
+	}
+}
+
+// NewIntervalLimiter releases one request every interval without bursting,
+// it panics if interval is not positive
+func NewIntervalLimiter(interval time.Duration) *TokenBucket {
+	if interval <= 0 {
+		panic("non-positive interval for NewIntervalLimiter")
+	}
+	return NewTokenBucket(float64(time.Second)/float64(interval), 1)
+}
+
+// refill must be called with the lock held
+func (t *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(t.last).Seconds()
+	t.last = now
+	t.tokens = t.tokens + elapsed*t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
+
+// Wait reserves a token and sleeps until it is available. The reservation is
+// returned if ctx is done before then
+func (t *TokenBucket) Wait(ctx context.Context) error {
+	t.lock.Lock()
+	t.refill(time.Now())
+	t.tokens = t.tokens - 1
+	var wait time.Duration
+	if t.tokens < 0 {
+		wait = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.lock.Unlock()
+
+	if err := sleepContext(ctx, wait); err != nil {
+		t.lock.Lock()
+		t.tokens = t.tokens + 1
+		t.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *TokenBucket) Observe(*http.Response, error) {}
+
+// Rate returns the current refill rate in requests per second
+func (t *TokenBucket) Rate() float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.rate
+}
+
+// SetRate changes the refill rate, tokens accumulated so far are kept. It
+// panics if rate is not positive
+func (t *TokenBucket) SetRate(rate float64) {
+	if rate <= 0 {
+		panic("non-positive rate for SetRate")
+	}
+	t.lock.Lock()
+	t.refill(time.Now())
+	t.rate = rate
+	t.lock.Unlock()
+}
+
+// AdaptiveLimiter is a token bucket whose rate follows additive increase,
+// multiplicative decrease. Throttling responses cut the rate by Decrease and
+// every other response adds Increase, within MinRate and MaxRate
+type AdaptiveLimiter struct {
+	*TokenBucket
+	MinRate  float64
+	MaxRate  float64
+	Increase float64
+	Decrease float64
+	// Throttled lists the status codes that slow the limiter down
+	Throttled []int
+	// Cooldown is the minimum time between two decreases so that a burst of
+	// throttled responses to requests already in flight counts once
+	Cooldown time.Duration
+
+	lastDecrease time.Time
+	pausedUntil  time.Time
+	lock         *sync.Mutex
+}
+
+// NewAdaptiveLimiter starts at maxRate and backs off on 429 and 503 responses.
+// It panics unless 0 < minRate <= maxRate
+func NewAdaptiveLimiter(minRate, maxRate float64, burst int) *AdaptiveLimiter {
+	if minRate <= 0 || minRate > maxRate {
+		panic("invalid rates for NewAdaptiveLimiter")
+	}
+	return &AdaptiveLimiter{
+		TokenBucket: NewTokenBucket(maxRate, burst),
+		MinRate:     minRate,
+		MaxRate:     maxRate,
+		Increase:    maxRate / 50,
+		Decrease:    0.5,
+		Throttled:   []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		Cooldown:    time.Second,
+		lock:        new(sync.Mutex),
+	}
+}
+
+// Wait additionally holds requests back while the upstream asked us to pause
+// through Retry-After
+func (a *AdaptiveLimiter) Wait(ctx context.Context) error {
+	a.lock.Lock()
+	pause := time.Until(a.pausedUntil)
+	a.lock.Unlock()
+	if err := sleepContext(ctx, pause); err != nil {
+		return err
+	}
+	return a.TokenBucket.Wait(ctx)
+}
+
+func (a *AdaptiveLimiter) Observe(resp *http.Response, err error) {
+	if resp == nil {
+		return
+	}
+	throttled := false
+	for _, s := range a.Throttled {
+		if resp.StatusCode == s {
+			throttled = true
+			break
+		}
+	}
+	// The rate is read under the lock so that a concurrent increase can not
+	// write back the rate from before a decrease
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	rate := a.Rate()
+	if !throttled {
+		rate = rate + a.Increase
+		if rate > a.MaxRate {
+			rate = a.MaxRate
+		}
+		a.SetRate(rate)
+		return
+	}
+	now := time.Now()
+	if after, ok := retryAfter(resp); ok && now.Add(after).After(a.pausedUntil) {
+		a.pausedUntil = now.Add(after)
+	}
+	if now.Sub(a.lastDecrease) < a.Cooldown {
+		return
+	}
+	a.lastDecrease = now
+	rate = rate * a.Decrease
+	if rate < a.MinRate {
+		rate = a.MinRate
+	}
+	a.SetRate(rate)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveLimiterThrottledUnderLoad checks that a 429 lowers the rate
+// even when a success is observed at the same time. The lock of the limiter
+// is held while both observations start so that they run together
+func TestAdaptiveLimiterThrottledUnderLoad(t *testing.T) {
+	a := NewAdaptiveLimiter(1e-3, 1e3, 1)
+	// Successes leave the rate unchanged so that only the decreases move it
+	a.Increase = 0
+	a.Decrease = 0.9
+	a.Cooldown = 0
+	ok := &http.Response{StatusCode: http.StatusOK}
+	throttled := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+
+	want := a.MaxRate
+	for i := 0; i < 50; i++ {
+		wg := new(sync.WaitGroup)
+		wg.Add(2)
+		a.lock.Lock()
+		go func() {
+			defer wg.Done()
+			a.Observe(throttled, nil)
+		}()
+		time.Sleep(time.Millisecond)
+		go func() {
+			defer wg.Done()
+			a.Observe(ok, nil)
+		}()
+		time.Sleep(time.Millisecond)
+		a.lock.Unlock()
+		wg.Wait()
+
+		want = want * a.Decrease
+		if rate := a.Rate(); rate != want {
+			t.Fatalf("rate %v after %d throttled responses, want %v", rate, i+1, want)
+		}
+	}
+}
+
+func TestLimitersRejectNonPositiveRates(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func()
+	}{
+		{"zero token bucket rate", func() { NewTokenBucket(0, 1) }},
+		{"negative token bucket rate", func() { NewTokenBucket(-1, 1) }},
+		{"zero interval", func() { NewIntervalLimiter(0) }},
+		{"zero minimum rate", func() { NewAdaptiveLimiter(0, 1, 1) }},
+		{"minimum above maximum", func() { NewAdaptiveLimiter(2, 1, 1) }},
+		{"zero SetRate", func() { NewTokenBucket(1, 1).SetRate(0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("did not panic")
+				}
+			}()
+			tt.new()
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zeu5/visualizations/util"
+)
+
+// PrometheusObserver exports the activity of a ThrottledClient as prometheus
+// metrics labelled by lane
+type PrometheusObserver struct {
+	queued    *prometheus.GaugeVec
+	queueWait *prometheus.HistogramVec
+	inFlight  *prometheus.GaugeVec
+	latency   *prometheus.HistogramVec
+	responses *prometheus.CounterVec
+	retries   *prometheus.CounterVec
+	bytes     *prometheus.CounterVec
+}
+
+// NewPrometheusObserver creates the metrics and registers them with reg
+func NewPrometheusObserver(reg prometheus.Registerer) (*PrometheusObserver, error) {
+	o := &PrometheusObserver{
+		queued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "throttled_client",
+			Name:      "requests_queued",
+			Help:      "Requests waiting in the queue of the lane",
+		}, []string{"lane"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "throttled_client",
+			Name:      "queue_wait_seconds",
+			Help:      "Time requests spent queued",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"lane"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "throttled_client",
+			Name:      "requests_in_flight",
+			Help:      "Requests sent and waiting for a response",
+		}, []string{"lane"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "throttled_client",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken for the response headers of every attempt",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"lane"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "throttled_client",
+			Name:      "responses_total",
+			Help:      "Attempts by response status code, error when no response was received",
+		}, []string{"lane", "code"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "throttled_client",
+			Name:      "retries_total",
+			Help:      "Attempts that retried a failed request",
+		}, []string{"lane"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "throttled_client",
+			Name:      "response_bytes_total",
+			Help:      "Bytes read from response bodies",
+		}, []string{"lane"}),
+	}
+	collectors := []prometheus.Collector{
+		o.queued, o.queueWait, o.inFlight, o.latency, o.responses, o.retries, o.bytes,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (o *PrometheusObserver) RequestQueued(lane string) {
+	o.queued.WithLabelValues(lane).Inc()
+}
+
+func (o *PrometheusObserver) RequestDequeued(lane string, wait time.Duration) {
+	o.queued.WithLabelValues(lane).Dec()
+	o.queueWait.WithLabelValues(lane).Observe(wait.Seconds())
+}
+
+func (o *PrometheusObserver) RequestStarted(lane string, _ *http.Request) {
+	o.inFlight.WithLabelValues(lane).Inc()
+}
+
+func (o *PrometheusObserver) RequestFinished(lane string, _ *http.Request, resp *http.Response, latency time.Duration, err error) {
+	o.inFlight.WithLabelValues(lane).Dec()
+	o.latency.WithLabelValues(lane).Observe(latency.Seconds())
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	o.responses.WithLabelValues(lane, code).Inc()
+}
+
+func (o *PrometheusObserver) RequestRetried(lane string, _ *http.Request, _ int) {
+	o.retries.WithLabelValues(lane).Inc()
+}
+
+func (o *PrometheusObserver) BodyRead(lane string, bytes int64) {
+	o.bytes.WithLabelValues(lane).Add(float64(bytes))
+}
+
+var (
+	defaultObserver *PrometheusObserver
+	defaultOnce     = new(sync.Once)
+)
+
+// DefaultObserver returns an observer registered with the default prometheus
+// registry, shared by every client of the process
+func DefaultObserver() util.Observer {
+	defaultOnce.Do(func() {
+		o, err := NewPrometheusObserver(prometheus.DefaultRegisterer)
+		if err != nil {
+			panic(err)
+		}
+		defaultObserver = o
+	})
+	return defaultObserver
+}
+
+// Handler serves the metrics of the default prometheus registry
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zeu5/visualizations/util"
+)
+
+func TestPrometheusObserver(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("records"))
+	}))
+	defer srv.Close()
+	reg := prometheus.NewRegistry()
+	o, err := NewPrometheusObserver(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := util.DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.Jitter = 0
+	n := util.NewThrottledClient(time.Millisecond, 0, util.WithRetryPolicy(policy), util.WithObserver(o))
+	n.Start()
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := n.Do(req).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	n.Stop()
+
+	tests := []struct {
+		name   string
+		metric prometheus.Collector
+		want   float64
+	}{
+		{"queued", o.queued.WithLabelValues(util.DefaultLane), 0},
+		{"in flight", o.inFlight.WithLabelValues(util.DefaultLane), 0},
+		{"throttled responses", o.responses.WithLabelValues(util.DefaultLane, "429"), 1},
+		{"ok responses", o.responses.WithLabelValues(util.DefaultLane, "200"), 1},
+		{"retries", o.retries.WithLabelValues(util.DefaultLane), 1},
+		{"bytes", o.bytes.WithLabelValues(util.DefaultLane), float64(len("records"))},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.metric); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				counts[f.GetName()] = h.GetSampleCount()
+			}
+		}
+	}
+	if counts["throttled_client_queue_wait_seconds"] != 1 || counts["throttled_client_request_duration_seconds"] != 2 {
+		t.Fatalf("unexpected histogram counts %v", counts)
+	}
+}
+
+func TestPrometheusObserverRegistersOnce(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := NewPrometheusObserver(reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPrometheusObserver(reg); err == nil {
+		t.Fatal("metrics registered twice with the same registry")
+	}
+}
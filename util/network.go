@@ -1,33 +1,144 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 )
 
-type ReqRes struct {
-	Request       *http.Request
-	Response      chan *http.Response
-	RequestGroup  RequestGroup
-	ResponseGroup chan []*http.Response
-	Error         chan error
+// request is a request or request group waiting in, or sent from, a lane
+type request struct {
+	req    *http.Request
+	group  RequestGroup
+	future *Future
+
+	ctx      context.Context
+	laneName string
+	lane     *lane
+	priority Priority
+	seq      uint64
+	queuedAt time.Time
+}
+
+func newRequest(ctx context.Context) *request {
+	return &request{
+		future: newFuture(),
+		ctx:    ctx,
+	}
+}
+
+// watch fails the request as soon as its context is done, so that a caller
+// waiting on the future is released even while the request is queued
+func (r *request) watch() {
+	if r.ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			r.fail(r.ctx.Err())
+		case <-r.future.done:
+		}
+	}()
+}
+
+func (r *request) finished() bool {
+	return r.future.completed()
+}
+
+func (r *request) fail(err error) {
+	r.future.complete(Result{Err: err})
+}
+
+func (r *request) respond(resp *http.Response) {
+	if !r.future.complete(Result{Response: resp}) {
+		resp.Body.Close()
+	}
 }
 
+func (r *request) respondGroup(responses []*http.Response) {
+	if !r.future.complete(Result{Responses: responses}) {
+		closeAll(responses)
+	}
+}
+
+func closeAll(responses []*http.Response) {
+	for _, resp := range responses {
+		resp.Body.Close()
+	}
+}
+
+// ErrClientClosed is returned for requests made after the client is stopped
+// and delivered to queued requests that are dropped by Abort
+var ErrClientClosed = errors.New("network closed")
+
 type ThrottledClient struct {
 	interval   time.Duration
-	ticker     *time.Ticker
-	pool       chan *ReqRes
 	maxsize    int
-	stopCh     chan bool
+	lanes      *lanes
 	httpClient *http.Client
+	retry      *RetryPolicy
+	observer   Observer
+	buffering  BufferOptions
 	wg         *sync.WaitGroup
+
+	// abortCh is closed to cancel in flight requests
+	abortCh   chan struct{}
+	abortOnce *sync.Once
+	started   bool
+	lock      *sync.Mutex
+}
+
+// ClientOptions configure the ThrottledClient when it is created
+type ClientOptions func(*ThrottledClient)
+
+// WithHTTPClient applies f to the underlying http.Client
+func WithHTTPClient(f func(*http.Client)) ClientOptions {
+	return func(n *ThrottledClient) {
+		f(n.httpClient)
+	}
+}
+
+// WithLimiter paces the default lane with l instead of one request every interval
+func WithLimiter(l Limiter) ClientOptions {
+	return func(n *ThrottledClient) {
+		def, _ := n.lanes.named(DefaultLane)
+		def.limiter = l
+	}
+}
+
+// WithLane adds a lane with its own limits. Requests are routed to it by
+// the hosts of the config or explicitly with OnLane
+func WithLane(name string, c LaneConfig) ClientOptions {
+	return func(n *ThrottledClient) {
+		limiter := c.Limiter
+		if limiter == nil {
+			limiter = NewIntervalLimiter(n.interval)
+		}
+		queueSize := c.QueueSize
+		if queueSize == 0 {
+			queueSize = n.maxsize
+		}
+		n.lanes.add(n.newLane(name, limiter, c.Concurrency, queueSize), c.Hosts)
+	}
 }
 
-type ClientOptions func(*http.Client)
+// WithRetryPolicy retries failed requests, and failed steps of a request
+// group, according to p
+func WithRetryPolicy(p *RetryPolicy) ClientOptions {
+	return func(n *ThrottledClient) {
+		n.retry = p
+	}
+}
 
+// NewThrottledClient creates a client whose default lane sends one request
+// every interval, unless a Limiter is provided, and queues up to maxsize
+// requests. A maxsize below 1 does not bound the queue. It panics if interval
+// is not positive
 func NewThrottledClient(interval time.Duration, maxsize int, opts ...ClientOptions) *ThrottledClient {
 	httpClient := &http.Client{
 		Transport: &http.Transport{
@@ -40,134 +151,371 @@ func NewThrottledClient(interval time.Duration, maxsize int, opts ...ClientOptio
 			ExpectContinueTimeout: 1 * time.Second,
 		},
 	}
-	for _, o := range opts {
-		o(httpClient)
-	}
-	return &ThrottledClient{
+	n := &ThrottledClient{
 		interval:   interval,
-		ticker:     time.NewTicker(interval),
-		pool:       make(chan *ReqRes, maxsize),
 		maxsize:    maxsize,
-		stopCh:     make(chan bool),
+		lanes:      newLanes(),
 		httpClient: httpClient,
 		wg:         new(sync.WaitGroup),
+		abortCh:    make(chan struct{}),
+		abortOnce:  new(sync.Once),
+		lock:       new(sync.Mutex),
+		observer:   NopObserver{},
+	}
+	n.lanes.add(n.newLane(DefaultLane, NewIntervalLimiter(interval), 0, maxsize), nil)
+	for _, o := range opts {
+		o(n)
+	}
+	return n
+}
+
+// newLane creates a lane whose queue reports requests dropped after being
+// cancelled to the observer
+func (n *ThrottledClient) newLane(name string, limiter Limiter, concurrency, queueSize int) *lane {
+	l := newLane(name, limiter, concurrency, queueSize)
+	l.queue.dropped = func(r *request) {
+		n.observer.RequestDequeued(name, time.Since(r.queuedAt))
 	}
+	return l
 }
 
 func (n *ThrottledClient) Start() {
-	go n.poll()
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.started {
+		return
+	}
+	n.started = true
+	for _, l := range n.lanes.all() {
+		go n.poll(l)
+	}
+}
+
+// Stats returns a snapshot of every lane
+func (n *ThrottledClient) Stats() []LaneStats {
+	stats := make([]LaneStats, 0)
+	for _, l := range n.lanes.all() {
+		stats = append(stats, l.stats())
+	}
+	return stats
+}
+
+func (n *ThrottledClient) closeQueues() {
+	for _, l := range n.lanes.all() {
+		l.queue.close()
+	}
 }
 
+func (n *ThrottledClient) waitPolls() {
+	for _, l := range n.lanes.all() {
+		<-l.pollDone
+	}
+}
+
+// Stop stops accepting requests and waits for the queued and in flight
+// requests to complete
 func (n *ThrottledClient) Stop() {
-	close(n.stopCh)
-	close(n.pool)
-	n.ticker.Stop()
+	n.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting requests and waits for the queued and in flight
+// requests to complete. If ctx is done first the remaining requests are
+// aborted and ctx.Err() is returned
+func (n *ThrottledClient) Shutdown(ctx context.Context) error {
+	n.closeQueues()
+	if !n.isStarted() {
+		n.Abort()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		n.waitPolls()
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.Abort()
+		return ctx.Err()
+	}
+}
+
+// Abort stops accepting requests, fails the queued ones with ErrClientClosed,
+// cancels the ones in flight and waits for them to return
+func (n *ThrottledClient) Abort() {
+	n.closeQueues()
+	n.abortOnce.Do(func() {
+		close(n.abortCh)
+	})
+	for _, l := range n.lanes.all() {
+		for _, r := range l.queue.drain() {
+			n.observer.RequestDequeued(l.name, time.Since(r.queuedAt))
+			r.fail(ErrClientClosed)
+		}
+	}
+	if n.isStarted() {
+		n.waitPolls()
+	}
 	n.wg.Wait()
 }
 
-func (n *ThrottledClient) request(r *ReqRes) {
-	resp, err := n.httpClient.Do(r.Request)
-	if err != nil {
-		go func() {
-			r.Error <- err
-		}()
-		n.wg.Done()
-		return
+func (n *ThrottledClient) isStarted() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.started
+}
+
+func (n *ThrottledClient) aborted() bool {
+	select {
+	case <-n.abortCh:
+		return true
+	default:
+		return false
 	}
+}
+
+// requestContext derives the context a request is sent with, it is cancelled
+// along with the request context or when the client is aborted
+func (n *ThrottledClient) requestContext(r *request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.ctx)
 	go func() {
-		r.Response <- resp
+		select {
+		case <-n.abortCh:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
-	n.wg.Done()
+	return ctx, cancel
 }
-func (n *ThrottledClient) requestGroup(r *ReqRes) {
-	req := r.RequestGroup.Next(nil)
+
+// failure reports requests cancelled by Abort as ErrClientClosed rather
+// than as a context error
+func (n *ThrottledClient) failure(r *request, err error) error {
+	if r.ctx.Err() == nil && n.aborted() {
+		return ErrClientClosed
+	}
+	return err
+}
+
+func (n *ThrottledClient) doRequest(ctx context.Context, r *request) error {
+	if err := ctx.Err(); err != nil {
+		r.fail(n.failure(r, err))
+		return err
+	}
+	resp, err := n.send(ctx, r.lane, r.req, true)
+	if err != nil {
+		r.fail(n.failure(r, err))
+		return err
+	}
+	r.respond(resp)
+	return nil
+}
+
+func (n *ThrottledClient) doGroup(ctx context.Context, r *request) error {
+	req, err := r.group.Next(nil)
+	if err != nil {
+		err = &StepError{Err: err}
+	}
 	responses := make([]*http.Response, 0)
-	var err error
 	for req != nil && err == nil {
-		resp, e := n.httpClient.Do(req)
+		stepNum := len(responses) + 1
+		if e := ctx.Err(); e != nil {
+			err = &StepError{Step: stepNum, Err: n.failure(r, e)}
+			continue
+		}
+		// The first request was paced when the group was dispatched
+		resp, e := n.send(ctx, r.lane, req, stepNum == 1)
+		if e != nil {
+			err = &StepError{Step: stepNum, Err: n.failure(r, e)}
+			continue
+		}
+		step, e := n.buffering.bufferStep(resp)
 		if e != nil {
-			err = e
+			err = &StepError{Step: stepNum, StatusCode: resp.StatusCode, Err: e}
 			continue
 		}
 		responses = append(responses, resp)
-		req = r.RequestGroup.Next(resp)
+		req, e = r.group.Next(step)
+		step.Body.Close()
+		if e != nil {
+			err = &StepError{Step: stepNum, StatusCode: resp.StatusCode, Err: e}
+		}
 	}
 	if err != nil {
-		go func() {
-			r.Error <- err
-		}()
-		n.wg.Done()
-		return
+		closeAll(responses)
+		r.fail(err)
+		return err
 	}
-	go func() {
-		r.ResponseGroup <- responses
-	}()
-	n.wg.Done()
+	r.respondGroup(responses)
+	return nil
 }
 
-func (n *ThrottledClient) poll() {
-	for {
-		select {
-		case <-n.ticker.C:
-			reqRes, more := <-n.pool
-			if reqRes != nil {
-				n.wg.Add(1)
-				if reqRes.Request != nil {
-					go n.request(reqRes)
-				} else if reqRes.RequestGroup != nil {
-					go n.requestGroup(reqRes)
-				}
-			}
-			if !more {
-				goto LOOP_OUT
+// send performs the request, retrying according to the retry policy. The
+// response of the last attempt is returned when all attempts are exhausted.
+// Every attempt waits on the limiter except the first one when paced is set
+func (n *ThrottledClient) send(ctx context.Context, l *lane, req *http.Request, paced bool) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	attempts := n.retry.attempts()
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 || !paced {
+			if err := l.limiter.Wait(ctx); err != nil {
+				return nil, err
 			}
-		case <-n.stopCh:
-			goto LOOP_OUT
 		}
+		if attempt > 1 {
+			n.observer.RequestRetried(l.name, req, attempt)
+		}
+		n.observer.RequestStarted(l.name, req)
+		start := time.Now()
+		resp, err := n.httpClient.Do(req)
+		n.observer.RequestFinished(l.name, req, resp, time.Since(start), err)
+		l.limiter.Observe(resp, err)
+		if resp != nil {
+			resp.Body = &countingBody{ReadCloser: resp.Body, lane: l.name, observer: n.observer}
+		}
+		if attempt >= attempts || !n.retry.retryable(resp, err) {
+			return resp, err
+		}
+		next, ok := rewind(req)
+		if !ok {
+			return resp, err
+		}
+		wait := n.retry.delay(attempt, resp)
+		if resp != nil {
+			discard(resp)
+		}
+		if e := sleepContext(ctx, wait); e != nil {
+			return nil, e
+		}
+		req = next
 	}
-LOOP_OUT:
-	for r := range n.pool {
-		go func(rr *ReqRes) {
-			rr.Error <- errors.New("network closed")
-		}(r)
+}
+
+// poll dispatches the requests queued on the lane as its limiter and
+// concurrency allow until the queue is closed and empty
+func (n *ThrottledClient) poll(l *lane) {
+	defer close(l.pollDone)
+	for {
+		r, ok := l.queue.pop()
+		if !ok {
+			return
+		}
+		n.observer.RequestDequeued(l.name, time.Since(r.queuedAt))
+		// Requests cancelled while queued do not use up a token
+		if r.finished() {
+			continue
+		}
+		ctx, cancel := n.requestContext(r)
+		if err := l.acquire(ctx); err != nil {
+			cancel()
+			r.fail(n.failure(r, err))
+			continue
+		}
+		if err := l.limiter.Wait(ctx); err != nil {
+			cancel()
+			l.release(true)
+			r.fail(n.failure(r, err))
+			continue
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			defer cancel()
+			var err error
+			if r.req != nil {
+				err = n.doRequest(ctx, r)
+			} else if r.group != nil {
+				err = n.doGroup(ctx, r)
+			}
+			l.release(err != nil)
+		}()
 	}
 }
 
-func (n *ThrottledClient) Do(r *http.Request) (*ReqRes, error) {
-	select {
-	case <-n.stopCh:
-		return nil, errors.New("network closed")
-	default:
+// Do queues the request using the context of the request
+func (n *ThrottledClient) Do(req *http.Request, opts ...RequestOptions) *Future {
+	return n.DoContext(req.Context(), req, opts...)
+}
+
+// DoContext queues the request. Cancelling ctx removes a queued request from
+// the lane and aborts it when in flight, failing the future with ctx.Err()
+func (n *ThrottledClient) DoContext(ctx context.Context, req *http.Request, opts ...RequestOptions) *Future {
+	r := newRequest(ctx)
+	r.req = req
+	return n.enqueue(r, opts)
+}
+
+// enqueue routes the request to its lane and blocks while the lane queue is
+// full. The future fails with ErrClientClosed once the client is stopped
+func (n *ThrottledClient) enqueue(r *request, opts []RequestOptions) *Future {
+	for _, o := range opts {
+		o(r)
 	}
-	reqRes := &ReqRes{
-		Request:  r,
-		Response: make(chan *http.Response),
-		Error:    make(chan error),
+	if r.laneName != "" {
+		l, err := n.lanes.named(r.laneName)
+		if err != nil {
+			return failedFuture(err)
+		}
+		r.lane = l
+	} else {
+		r.lane = n.lanes.route(r.req)
 	}
-
-	// Can panic here due to race conditions. Need to use a slice to manage requests
-	// If Stop and Do are called simultaneously
-	n.pool <- reqRes
-	return reqRes, nil
+	if err := r.ctx.Err(); err != nil {
+		return failedFuture(err)
+	}
+	r.queuedAt = time.Now()
+	n.observer.RequestQueued(r.lane.name)
+	if err := r.lane.queue.push(r.ctx, r); err != nil {
+		n.observer.RequestDequeued(r.lane.name, time.Since(r.queuedAt))
+		return failedFuture(err)
+	}
+	r.watch()
+	return r.future
 }
 
 // Do a group of requests together, pass response of previous to the get the next request
 type RequestGroup interface {
-	// First called with nil and continutes until nil is returned
-	Next(*http.Response) *http.Request
+	// First called with nil and continutes until a nil request or an error
+	// is returned. An error stops the group and fails it with a StepError.
+	// The body of the response is buffered as configured with WithBuffering
+	// and closed by the client once Next returns. The responses of the group
+	// are returned with their own unread copy of the body
+	Next(*http.Response) (*http.Request, error)
 }
 
-func (n *ThrottledClient) DoGroup(r RequestGroup) (*ReqRes, error) {
-	select {
-	case <-n.stopCh:
-		return nil, errors.New("network closed")
-	default:
-	}
-	reqRes := &ReqRes{
-		RequestGroup:  r,
-		ResponseGroup: make(chan []*http.Response),
-		Error:         make(chan error),
+// StepError is the error of a request group. Step is the request of the
+// group that failed or whose response was rejected by Next, starting at 1,
+// and is 0 when Next failed to build the first request. StatusCode is the
+// status of that response, 0 when there is none
+type StepError struct {
+	Step       int
+	StatusCode int
+	Err        error
+}
+
+func (e *StepError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("step %d (status %d): %s", e.Step, e.StatusCode, e.Err)
 	}
-	n.pool <- reqRes
-	return reqRes, nil
+	return fmt.Sprintf("step %d: %s", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// DoGroup queues the request group without a deadline
+func (n *ThrottledClient) DoGroup(group RequestGroup, opts ...RequestOptions) *Future {
+	return n.DoGroupContext(context.Background(), group, opts...)
+}
+
+// DoGroupContext queues the request group. ctx applies to every request of the
+// group and cancelling it stops the group between or during requests. Groups
+// go through the default lane unless OnLane is given
+func (n *ThrottledClient) DoGroupContext(ctx context.Context, group RequestGroup, opts ...RequestOptions) *Future {
+	r := newRequest(ctx)
+	r.group = group
+	return n.enqueue(r, opts)
 }
@@ -0,0 +1,383 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingServer answers once release is closed, started reports every
+// request it receives
+type blockingServer struct {
+	*httptest.Server
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingServer() *blockingServer {
+	s := &blockingServer{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.started <- struct{}{}
+		select {
+		case <-s.release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	return s
+}
+
+func newOKServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
+
+func get(t *testing.T, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// twoSteps is a request group requesting url twice
+type twoSteps struct {
+	url   string
+	steps int
+}
+
+func (g *twoSteps) Next(*http.Response) (*http.Request, error) {
+	if g.steps == 2 {
+		return nil, nil
+	}
+	g.steps++
+	return http.NewRequest("GET", g.url, nil)
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestStopCompletesQueuedRequests(t *testing.T) {
+	srv := newOKServer()
+	defer srv.Close()
+	n := NewThrottledClient(time.Millisecond, 0)
+	n.Start()
+
+	futures := make([]*Future, 10)
+	for i := range futures {
+		futures[i] = n.DoContext(context.Background(), get(t, srv.URL))
+	}
+	n.Stop()
+	for i, f := range futures {
+		resp, err := f.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestCallsAfterStopFail(t *testing.T) {
+	srv := newOKServer()
+	defer srv.Close()
+	n := NewThrottledClient(time.Millisecond, 0)
+	n.Start()
+	n.Stop()
+
+	if _, err := n.DoContext(context.Background(), get(t, srv.URL)).Wait(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("DoContext after Stop: got %v, want %v", err, ErrClientClosed)
+	}
+	if _, err := n.DoGroupContext(context.Background(), &twoSteps{url: srv.URL}).WaitAll(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("DoGroupContext after Stop: got %v, want %v", err, ErrClientClosed)
+	}
+	// Stopping twice is harmless
+	n.Stop()
+	n.Abort()
+}
+
+func TestAbortFailsQueuedRequests(t *testing.T) {
+	srv := newBlockingServer()
+	defer srv.Close()
+	defer close(srv.release)
+	// Only the first request is let through before Abort
+	n := NewThrottledClient(time.Hour, 0)
+	n.Start()
+
+	inFlight := n.DoContext(context.Background(), get(t, srv.URL))
+	waitFor(t, srv.started)
+	queued := make([]*Future, 5)
+	for i := range queued {
+		queued[i] = n.DoContext(context.Background(), get(t, srv.URL))
+	}
+	group := n.DoGroupContext(context.Background(), &twoSteps{url: srv.URL})
+	n.Abort()
+
+	if _, err := inFlight.Wait(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("in flight request: got %v, want %v", err, ErrClientClosed)
+	}
+	for i, f := range queued {
+		if _, err := f.Wait(context.Background()); !errors.Is(err, ErrClientClosed) {
+			t.Fatalf("queued request %d: got %v, want %v", i, err, ErrClientClosed)
+		}
+	}
+	if _, err := group.WaitAll(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("queued group: got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestAbortBeforeStart(t *testing.T) {
+	n := NewThrottledClient(time.Millisecond, 0)
+	f := n.DoContext(context.Background(), get(t, "http://127.0.0.1:1"))
+	n.Abort()
+	if _, err := f.Wait(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestShutdownDeadlineAborts(t *testing.T) {
+	srv := newBlockingServer()
+	defer srv.Close()
+	defer close(srv.release)
+	n := NewThrottledClient(time.Hour, 0)
+	n.Start()
+
+	inFlight := n.DoContext(context.Background(), get(t, srv.URL))
+	waitFor(t, srv.started)
+	queued := n.DoContext(context.Background(), get(t, srv.URL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	for _, f := range []*Future{inFlight, queued} {
+		if _, err := f.Wait(context.Background()); !errors.Is(err, ErrClientClosed) {
+			t.Fatalf("got %v, want %v", err, ErrClientClosed)
+		}
+	}
+}
+
+func TestShutdownWaitsForInFlight(t *testing.T) {
+	srv := newBlockingServer()
+	defer srv.Close()
+	n := NewThrottledClient(time.Millisecond, 0)
+	n.Start()
+
+	f := n.DoContext(context.Background(), get(t, srv.URL))
+	waitFor(t, srv.started)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(srv.release)
+	}()
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := f.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestCancelledContextReleasesQueuedRequest(t *testing.T) {
+	srv := newBlockingServer()
+	defer srv.Close()
+	defer close(srv.release)
+	n := NewThrottledClient(time.Hour, 0)
+	n.Start()
+	defer n.Abort()
+
+	n.DoContext(context.Background(), get(t, srv.URL)).Discard()
+	waitFor(t, srv.started)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := n.DoContext(ctx, get(t, srv.URL))
+	group := n.DoGroupContext(ctx, &twoSteps{url: srv.URL})
+	cancel()
+	if _, err := queued.Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("queued request: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := group.WaitAll(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("queued group: got %v, want %v", err, context.Canceled)
+	}
+
+	// Already cancelled contexts are not queued at all
+	if _, err := n.DoContext(ctx, get(t, srv.URL)).Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled request: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCancelledContextReleasesInFlightRequest(t *testing.T) {
+	srv := newBlockingServer()
+	defer srv.Close()
+	defer close(srv.release)
+	n := NewThrottledClient(time.Millisecond, 0)
+	n.Start()
+	defer n.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := n.DoContext(ctx, get(t, srv.URL))
+	waitFor(t, srv.started)
+	cancel()
+	if _, err := f.Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestConcurrentRequestsAndStop is meant to run with -race. Every future must
+// either succeed or fail with ErrClientClosed, whichever of Stop, Abort or
+// Shutdown ends the client
+func TestConcurrentRequestsAndStop(t *testing.T) {
+	srv := newOKServer()
+	defer srv.Close()
+	stops := map[string]func(n *ThrottledClient){
+		"Stop":  func(n *ThrottledClient) { n.Stop() },
+		"Abort": func(n *ThrottledClient) { n.Abort() },
+		"Shutdown": func(n *ThrottledClient) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			n.Shutdown(ctx)
+		},
+	}
+	for name, stop := range stops {
+		t.Run(name, func(t *testing.T) {
+			n := NewThrottledClient(time.Microsecond, 4)
+			n.Start()
+
+			wg := new(sync.WaitGroup)
+			errs := make(chan error, 400)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						var f *Future
+						if j%2 == 0 {
+							req, _ := http.NewRequest("GET", srv.URL, nil)
+							f = n.DoContext(context.Background(), req)
+						} else {
+							f = n.DoGroupContext(context.Background(), &twoSteps{url: srv.URL})
+						}
+						if (i+j)%5 == 0 {
+							f.Discard()
+							continue
+						}
+						res, err := f.wait(context.Background())
+						if err == nil {
+							err = res.Err
+							res.Close()
+						}
+						if err != nil && !errors.Is(err, ErrClientClosed) {
+							errs <- err
+						}
+					}
+				}(i)
+			}
+			time.Sleep(5 * time.Millisecond)
+			stop(n)
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestStepErrorFormat(t *testing.T) {
+	err := errors.New("bad page")
+	tests := []struct {
+		err  *StepError
+		want string
+	}{
+		{&StepError{Err: err}, "step 0: bad page"},
+		{&StepError{Step: 2, Err: err}, "step 2: bad page"},
+		{&StepError{Step: 3, StatusCode: http.StatusNotFound, Err: err}, "step 3 (status 404): bad page"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Fatalf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// rejectingGroup requests url steps times, Next rejects the response of step
+// reject with the error reason
+type rejectingGroup struct {
+	url    string
+	steps  int
+	reject int
+	reason error
+	step   int
+}
+
+func (g *rejectingGroup) Next(*http.Response) (*http.Request, error) {
+	if g.step == g.reject {
+		return nil, g.reason
+	}
+	if g.step == g.steps {
+		return nil, nil
+	}
+	g.step++
+	return http.NewRequest("GET", g.url, nil)
+}
+
+func TestGroupStepError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("not ready"))
+	}))
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	reason := errors.New("rejected")
+
+	tests := []struct {
+		name       string
+		group      *rejectingGroup
+		opts       []ClientOptions
+		step       int
+		statusCode int
+		want       error
+	}{
+		{"first request not built", &rejectingGroup{url: srv.URL, steps: 2, reject: 0, reason: reason}, nil, 0, 0, reason},
+		{"first response rejected", &rejectingGroup{url: srv.URL, steps: 2, reject: 1, reason: reason}, nil, 1, http.StatusAccepted, reason},
+		{"second response rejected", &rejectingGroup{url: srv.URL, steps: 3, reject: 2, reason: reason}, nil, 2, http.StatusAccepted, reason},
+		{"request failed", &rejectingGroup{url: closed.URL, steps: 2, reject: -1}, nil, 1, 0, nil},
+		{"body too large", &rejectingGroup{url: srv.URL, steps: 2, reject: -1}, []ClientOptions{WithBuffering(BufferOptions{MaxSize: 3})}, 1, http.StatusAccepted, ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewThrottledClient(time.Millisecond, 0, tt.opts...)
+			n.Start()
+			defer n.Stop()
+			_, err := n.DoGroup(tt.group).WaitAll(context.Background())
+			var stepErr *StepError
+			if !errors.As(err, &stepErr) {
+				t.Fatalf("got %v, want a StepError", err)
+			}
+			if stepErr.Step != tt.step || stepErr.StatusCode != tt.statusCode {
+				t.Fatalf("failed at step %d with status %d, want step %d with status %d", stepErr.Step, stepErr.StatusCode, tt.step, tt.statusCode)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want it to wrap %v", err, tt.want)
+			}
+		})
+	}
+}
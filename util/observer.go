@@ -0,0 +1,65 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of the activity of the ThrottledClient, to collect
+// metrics or trace requests. Methods are called concurrently and should not block
+type Observer interface {
+	// RequestQueued is called when a request or request group is queued
+	RequestQueued(lane string)
+	// RequestDequeued is called when a request leaves the queue, wait is the
+	// time spent queued
+	RequestDequeued(lane string, wait time.Duration)
+	// RequestStarted is called before every attempt of a request is sent
+	RequestStarted(lane string, req *http.Request)
+	// RequestFinished is called after every attempt, resp is nil on error
+	RequestFinished(lane string, req *http.Request, resp *http.Response, latency time.Duration, err error)
+	// RequestRetried is called before attempt is sent again
+	RequestRetried(lane string, req *http.Request, attempt int)
+	// BodyRead is called when a response body is closed with the bytes read
+	BodyRead(lane string, bytes int64)
+}
+
+// NopObserver ignores everything, embed it to implement part of Observer
+type NopObserver struct{}
+
+func (NopObserver) RequestQueued(string)                                                        {}
+func (NopObserver) RequestDequeued(string, time.Duration)                                       {}
+func (NopObserver) RequestStarted(string, *http.Request)                                        {}
+func (NopObserver) RequestFinished(string, *http.Request, *http.Response, time.Duration, error) {}
+func (NopObserver) RequestRetried(string, *http.Request, int)                                   {}
+func (NopObserver) BodyRead(string, int64)                                                      {}
+
+// WithObserver reports the activity of the client to o
+func WithObserver(o Observer) ClientOptions {
+	return func(n *ThrottledClient) {
+		n.observer = o
+	}
+}
+
+// countingBody reports the bytes read from a response body once it is closed
+type countingBody struct {
+	io.ReadCloser
+	lane     string
+	observer Observer
+	read     int64
+	closed   int32
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countingBody) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.observer.BodyRead(c.lane, atomic.LoadInt64(&c.read))
+	}
+	return c.ReadCloser.Close()
+}
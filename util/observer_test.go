@@ -0,0 +1,104 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver records the events reported by the client
+type recordingObserver struct {
+	events   []string
+	statuses []int
+	attempts []int
+	bytes    int64
+	lock     *sync.Mutex
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{lock: new(sync.Mutex)}
+}
+
+func (o *recordingObserver) record(event, lane string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, event+" "+lane)
+}
+
+func (o *recordingObserver) RequestQueued(lane string) {
+	o.record("queued", lane)
+}
+
+func (o *recordingObserver) RequestDequeued(lane string, wait time.Duration) {
+	o.record("dequeued", lane)
+}
+
+func (o *recordingObserver) RequestStarted(lane string, req *http.Request) {
+	o.record("started", lane)
+}
+
+func (o *recordingObserver) RequestFinished(lane string, req *http.Request, resp *http.Response, latency time.Duration, err error) {
+	o.record("finished", lane)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if resp != nil {
+		o.statuses = append(o.statuses, resp.StatusCode)
+	}
+}
+
+func (o *recordingObserver) RequestRetried(lane string, req *http.Request, attempt int) {
+	o.record("retried", lane)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.attempts = append(o.attempts, attempt)
+}
+
+func (o *recordingObserver) BodyRead(lane string, bytes int64) {
+	o.record("read", lane)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.bytes = o.bytes + bytes
+}
+
+func TestObserver(t *testing.T) {
+	s := newFlakyServer(1, http.StatusServiceUnavailable, nil)
+	defer s.Close()
+	o := newRecordingObserver()
+	n := NewThrottledClient(time.Millisecond, 0, WithRetryPolicy(fastRetries(3)), WithObserver(o),
+		WithLane("portal", LaneConfig{Hosts: []string{"127.0.0.1"}}))
+	n.Start()
+	resp, err := n.Do(get(t, s.URL)).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// A second close reports nothing
+	resp.Body.Close()
+	n.Stop()
+
+	// The body of the throttled attempt is discarded before the retry
+	want := []string{
+		"queued portal", "dequeued portal",
+		"started portal", "finished portal", "read portal",
+		"retried portal", "started portal", "finished portal", "read portal",
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if !reflect.DeepEqual(o.events, want) {
+		t.Fatalf("got events %v, want %v", o.events, want)
+	}
+	if !reflect.DeepEqual(o.statuses, []int{http.StatusServiceUnavailable, http.StatusOK}) {
+		t.Fatalf("got statuses %v", o.statuses)
+	}
+	if !reflect.DeepEqual(o.attempts, []int{2}) {
+		t.Fatalf("got retried attempts %v", o.attempts)
+	}
+	if o.bytes != int64(len("ok")) {
+		t.Fatalf("got %d bytes read, want %d", o.bytes, len("ok"))
+	}
+}
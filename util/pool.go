@@ -0,0 +1,162 @@
+package util
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Task is a unit of work run by a Pool. The context is cancelled when the
+// pool fails fast or its parent context is done
+type Task func(ctx context.Context) (interface{}, error)
+
+// TaskResult is the outcome of a task, Index is the order it was submitted in
+type TaskResult struct {
+	Index int
+	Value interface{}
+	Err   error
+}
+
+type indexedTask struct {
+	index int
+	task  Task
+}
+
+// Pool runs tasks on a bounded number of workers. Submit blocks while every
+// worker is busy so that large batches do not pile up goroutines
+type Pool struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	failFast bool
+	onDone   func(TaskResult)
+
+	tasks     chan indexedTask
+	workers   *sync.WaitGroup
+	closeOnce *sync.Once
+
+	results   []TaskResult
+	firstErr  error
+	submitted int
+	pending   int
+	lock      *sync.Mutex
+}
+
+// PoolOptions configure a Pool
+type PoolOptions func(*Pool)
+
+// FailFast cancels the remaining tasks once one fails. Tasks that did not
+// start yet fail with the error of the context
+func FailFast() PoolOptions {
+	return func(p *Pool) {
+		p.failFast = true
+	}
+}
+
+// OnTaskDone calls cb with the result of every task once it is done, from the
+// worker that ran it
+func OnTaskDone(cb func(TaskResult)) PoolOptions {
+	return func(p *Pool) {
+		p.onDone = cb
+	}
+}
+
+// NewPool starts a pool of workers, below 1 runs one worker. The pool must
+// be waited on to release them
+func NewPool(ctx context.Context, workers int, opts ...PoolOptions) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pool{
+		ctx:       ctx,
+		cancel:    cancel,
+		tasks:     make(chan indexedTask),
+		workers:   new(sync.WaitGroup),
+		closeOnce: new(sync.Once),
+		results:   make([]TaskResult, 0),
+		lock:      new(sync.Mutex),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for t := range p.tasks {
+		res := TaskResult{Index: t.index}
+		if err := p.ctx.Err(); err != nil {
+			res.Err = err
+		} else {
+			res.Value, res.Err = t.task(p.ctx)
+		}
+		p.finish(res)
+	}
+}
+
+func (p *Pool) finish(res TaskResult) {
+	p.lock.Lock()
+	p.results = append(p.results, res)
+	p.pending = p.pending - 1
+	if res.Err != nil && p.firstErr == nil {
+		p.firstErr = res.Err
+		if p.failFast {
+			p.cancel()
+		}
+	}
+	p.lock.Unlock()
+	if p.onDone != nil {
+		p.onDone(res)
+	}
+}
+
+// Submit queues the task, blocking until a worker takes it. Submit must not
+// be called after Wait
+func (p *Pool) Submit(task Task) {
+	p.lock.Lock()
+	t := indexedTask{index: p.submitted, task: task}
+	p.submitted = p.submitted + 1
+	p.pending = p.pending + 1
+	p.lock.Unlock()
+	p.tasks <- t
+}
+
+// Pending returns the number of submitted tasks that are not done yet
+func (p *Pool) Pending() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.pending
+}
+
+// Wait waits for the submitted tasks and stops the workers. It returns the
+// results in submission order and the first error a task returned
+func (p *Pool) Wait() ([]TaskResult, error) {
+	p.closeOnce.Do(func() {
+		close(p.tasks)
+	})
+	p.workers.Wait()
+	p.cancel()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	results := make([]TaskResult, len(p.results))
+	copy(results, p.results)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+	return results, p.firstErr
+}
+
+// RunTasks runs the tasks on a pool of workers and waits for them
+func RunTasks(ctx context.Context, workers int, tasks []Task, opts ...PoolOptions) ([]TaskResult, error) {
+	p := NewPool(ctx, workers, opts...)
+	for _, t := range tasks {
+		p.Submit(t)
+	}
+	return p.Wait()
+}
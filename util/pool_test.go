@@ -0,0 +1,206 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// valueTask returns i after sleeping for d
+func valueTask(i int, d time.Duration) Task {
+	return func(ctx context.Context) (interface{}, error) {
+		time.Sleep(d)
+		return i, nil
+	}
+}
+
+func TestRunTasksOrder(t *testing.T) {
+	tasks := make([]Task, 20)
+	for i := range tasks {
+		// Later tasks finish first
+		tasks[i] = valueTask(i, time.Duration(len(tasks)-i)*time.Millisecond)
+	}
+	results, err := RunTasks(context.Background(), 4, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(tasks) {
+		t.Fatalf("got %d results, want %d", len(results), len(tasks))
+	}
+	for i, res := range results {
+		if res.Index != i || res.Value != i || res.Err != nil {
+			t.Fatalf("result %d is %+v", i, res)
+		}
+	}
+}
+
+func TestRunTasksWorkers(t *testing.T) {
+	tests := []struct {
+		workers int
+		want    int32
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.workers), func(t *testing.T) {
+			var running, most int32
+			tasks := make([]Task, 12)
+			for i := range tasks {
+				tasks[i] = func(ctx context.Context) (interface{}, error) {
+					n := atomic.AddInt32(&running, 1)
+					for {
+						m := atomic.LoadInt32(&most)
+						if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+							break
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+					return nil, nil
+				}
+			}
+			if _, err := RunTasks(context.Background(), tt.workers, tasks); err != nil {
+				t.Fatal(err)
+			}
+			if most != tt.want {
+				t.Fatalf("ran %d tasks at once, want %d", most, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTasksErrors(t *testing.T) {
+	errOdd := errors.New("odd")
+	var ran int32
+	tasks := make([]Task, 6)
+	for i := range tasks {
+		i := i
+		tasks[i] = func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&ran, 1)
+			if i%2 == 1 {
+				return nil, fmt.Errorf("task %d: %w", i, errOdd)
+			}
+			return i, nil
+		}
+	}
+	// A single worker finishes the tasks in order, task 1 fails first
+	results, err := RunTasks(context.Background(), 1, tasks)
+	if err == nil || err.Error() != "task 1: odd" {
+		t.Fatalf("got %v, want the error of task 1", err)
+	}
+	if ran != int32(len(tasks)) {
+		t.Fatalf("ran %d of %d tasks, failures must not stop the others", ran, len(tasks))
+	}
+	for i, res := range results {
+		if i%2 == 1 && !errors.Is(res.Err, errOdd) {
+			t.Fatalf("task %d: got %v, want %v", i, res.Err, errOdd)
+		}
+		if i%2 == 0 && (res.Err != nil || res.Value != i) {
+			t.Fatalf("task %d: got %+v", i, res)
+		}
+	}
+}
+
+func TestRunTasksFailFast(t *testing.T) {
+	failed := errors.New("failed")
+	var ran int32
+	tasks := make([]Task, 5)
+	for i := range tasks {
+		i := i
+		tasks[i] = func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&ran, 1)
+			if i == 1 {
+				return nil, failed
+			}
+			return i, nil
+		}
+	}
+	results, err := RunTasks(context.Background(), 1, tasks, FailFast())
+	if err != failed {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	if ran != 2 {
+		t.Fatalf("ran %d tasks, want the 2 up to the failure", ran)
+	}
+	if results[0].Err != nil || results[1].Err != failed {
+		t.Fatalf("got results %+v", results[:2])
+	}
+	for _, res := range results[2:] {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Fatalf("task %d after the failure: got %v, want %v", res.Index, res.Err, context.Canceled)
+		}
+	}
+}
+
+// TestRunTasksCancelled cancels the parent context while a task runs, the
+// running task sees it and the queued ones never start
+func TestRunTasksCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	var ran int32
+	tasks := []Task{
+		func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&ran, 1)
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	for i := 0; i < 3; i++ {
+		tasks = append(tasks, func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&ran, 1)
+			return nil, nil
+		})
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	results, err := RunTasks(ctx, 1, tasks)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if ran != 1 {
+		t.Fatalf("ran %d tasks after the context was cancelled", ran-1)
+	}
+	for _, res := range results {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Fatalf("task %d: got %v, want %v", res.Index, res.Err, context.Canceled)
+		}
+	}
+}
+
+func TestPoolOnTaskDone(t *testing.T) {
+	lock := new(sync.Mutex)
+	done := make(map[int]int)
+	var p *Pool
+	p = NewPool(context.Background(), 2, OnTaskDone(func(res TaskResult) {
+		if pending := p.Pending(); pending < 0 {
+			t.Errorf("%d tasks pending", pending)
+		}
+		lock.Lock()
+		done[res.Index]++
+		lock.Unlock()
+	}))
+	for i := 0; i < 10; i++ {
+		p.Submit(valueTask(i, time.Millisecond))
+	}
+	if _, err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Pending() != 0 {
+		t.Fatalf("%d tasks pending after Wait", p.Pending())
+	}
+	for i := 0; i < 10; i++ {
+		if done[i] != 1 {
+			t.Fatalf("task %d reported done %d times", i, done[i])
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Priority orders the queued requests of a lane, higher priorities are sent
+// first and requests of the same priority are sent in the order queued
+type Priority int
+
+const (
+	// PriorityLow is meant for bulk downloads
+	PriorityLow Priority = -10
+	// PriorityNormal is the priority of requests that do not set one
+	PriorityNormal Priority = 0
+	// PriorityHigh is meant for interactive and metadata requests
+	PriorityHigh Priority = 10
+)
+
+// WithPriority queues the request ahead of requests with a lower priority
+func WithPriority(p Priority) RequestOptions {
+	return func(r *request) {
+		r.priority = p
+	}
+}
+
+// requestHeap implements heap.Interface ordering by priority then sequence
+type requestHeap []*request
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h requestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x interface{}) {
+	*h = append(*h, x.(*request))
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	last := len(old) - 1
+	r := old[last]
+	old[last] = nil
+	*h = old[:last]
+	return r
+}
+
+// queue holds the requests waiting for the ThrottledClient. Unlike a channel
+// it can be closed while producers are still pushing
+type queue struct {
+	items   requestHeap
+	seq     uint64
+	maxsize int
+	closed  bool
+	// changed is closed and replaced whenever items or closed change
+	changed chan struct{}
+	// dropped is called with the lock held for requests removed by prune
+	dropped func(*request)
+	lock    *sync.Mutex
+}
+
+// newQueue creates a queue holding up to maxsize requests, a maxsize below 1
+// leaves the queue unbounded
+func newQueue(maxsize int) *queue {
+	return &queue{
+		items:   make(requestHeap, 0),
+		maxsize: maxsize,
+		changed: make(chan struct{}),
+		lock:    new(sync.Mutex),
+	}
+}
+
+// broadcast must be called with the lock held
+func (q *queue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// prune drops requests that already finished while waiting, must be called
+// with the lock held
+func (q *queue) prune() {
+	pending := q.items[:0]
+	for _, r := range q.items {
+		if !r.finished() {
+			pending = append(pending, r)
+		} else if q.dropped != nil {
+			q.dropped(r)
+		}
+	}
+	for i := len(pending); i < len(q.items); i++ {
+		q.items[i] = nil
+	}
+	q.items = pending
+	heap.Init(&q.items)
+}
+
+// push blocks while the queue is full and fails once the queue is closed
+func (q *queue) push(ctx context.Context, r *request) error {
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return ErrClientClosed
+		}
+		if q.maxsize > 0 && len(q.items) >= q.maxsize {
+			q.prune()
+		}
+		if q.maxsize < 1 || len(q.items) < q.maxsize {
+			q.seq++
+			r.seq = q.seq
+			heap.Push(&q.items, r)
+			q.broadcast()
+			q.lock.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pop blocks until a request is available and returns the one with the
+// highest priority. It returns false once the queue is closed and empty
+func (q *queue) pop() (*request, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.items) > 0 {
+			r := heap.Pop(&q.items).(*request)
+			q.broadcast()
+			q.lock.Unlock()
+			return r, true
+		}
+		if q.closed {
+			q.lock.Unlock()
+			return nil, false
+		}
+		changed := q.changed
+		q.lock.Unlock()
+		<-changed
+	}
+}
+
+// close stops the queue from accepting requests, queued requests can still
+// be popped
+func (q *queue) close() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		q.broadcast()
+	}
+	q.lock.Unlock()
+}
+
+// drain removes and returns all queued requests
+func (q *queue) drain() []*request {
+	q.lock.Lock()
+	items := []*request(q.items)
+	q.items = make(requestHeap, 0)
+	q.broadcast()
+	q.lock.Unlock()
+	return items
+}
+
+func (q *queue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func queued(t *testing.T, q *queue, priority Priority) *request {
+	t.Helper()
+	r := newRequest(context.Background())
+	r.priority = priority
+	if err := q.push(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestQueueOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []Priority
+		// want lists the pushed requests by index in the order popped
+		want []int
+	}{
+		{"same priority in order queued", []Priority{0, 0, 0, 0}, []int{0, 1, 2, 3}},
+		{"higher priority first", []Priority{PriorityLow, PriorityNormal, PriorityHigh}, []int{2, 1, 0}},
+		{"ties keep the order queued", []Priority{PriorityLow, PriorityHigh, PriorityLow, PriorityHigh, PriorityNormal}, []int{1, 3, 4, 0, 2}},
+		{"custom priorities", []Priority{5, -3, 20, 5, -3}, []int{2, 0, 3, 1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(0)
+			pushed := make(map[*request]int)
+			for i, p := range tt.priorities {
+				pushed[queued(t, q, p)] = i
+			}
+			q.close()
+			got := make([]int, 0)
+			for {
+				r, ok := q.pop()
+				if !ok {
+					break
+				}
+				got = append(got, pushed[r])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("popped %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestQueueOrderInterleaved pops while pushing, later high priority requests
+// still overtake the queued ones
+func TestQueueOrderInterleaved(t *testing.T) {
+	q := newQueue(0)
+	low := queued(t, q, PriorityLow)
+	normal := queued(t, q, PriorityNormal)
+	if r, _ := q.pop(); r != normal {
+		t.Fatal("normal priority request not popped first")
+	}
+	high := queued(t, q, PriorityHigh)
+	normal2 := queued(t, q, PriorityNormal)
+	for _, want := range []*request{high, normal2, low} {
+		if r, _ := q.pop(); r != want {
+			t.Fatalf("popped priority %d, want %d", r.priority, want.priority)
+		}
+	}
+}
+
+func TestQueueFullDropsFinished(t *testing.T) {
+	q := newQueue(2)
+	done := queued(t, q, PriorityNormal)
+	queued(t, q, PriorityNormal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.push(ctx, newRequest(context.Background())); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("push to a full queue returned %v", err)
+	}
+
+	dropped := 0
+	q.dropped = func(*request) { dropped++ }
+	done.fail(context.Canceled)
+	queued(t, q, PriorityNormal)
+	if dropped != 1 || q.len() != 2 {
+		t.Fatalf("dropped %d requests, %d queued", dropped, q.len())
+	}
+}
+
+func TestQueueClosed(t *testing.T) {
+	q := newQueue(0)
+	r := queued(t, q, PriorityNormal)
+	q.close()
+	if err := q.push(context.Background(), newRequest(context.Background())); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("push after close returned %v", err)
+	}
+	if got, ok := q.pop(); !ok || got != r {
+		t.Fatal("queued request not popped after close")
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop of a closed and empty queue succeeded")
+	}
+}
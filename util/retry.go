@@ -0,0 +1,177 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is attempted again and how long
+// to wait before doing so. The zero value does not retry
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is doubled after every attempt until MaxDelay is reached
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay that is randomised, between 0 and 1
+	Jitter float64
+	// RetryStatus lists the response status codes that are retried
+	RetryStatus []int
+	// RetryError reports whether a transport error is retried. Defaults to
+	// IsTemporaryError when nil
+	RetryError func(error) bool
+	// RespectRetryAfter waits for the duration in the Retry-After header of
+	// the response when it is longer than the backoff, capped at MaxDelay
+	RespectRetryAfter bool
+}
+
+// DefaultRetryPolicy retries throttled and server error responses along with
+// temporary network errors up to 5 times
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.5,
+		RetryStatus: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RespectRetryAfter: true,
+	}
+}
+
+// IsTemporaryError reports whether err is a network error worth retrying:
+// timeouts, refused or reset connections and connections closed mid response
+func IsTemporaryError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if p.RetryError != nil {
+			return p.RetryError(err)
+		}
+		return IsTemporaryError(err)
+	}
+	for _, s := range p.RetryStatus {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterLock = new(sync.Mutex)
+)
+
+// delay returns the wait before the attempt following attempt (starting at 1)
+func (p *RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d = d * 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 && d > 0 {
+		spread := time.Duration(float64(d) * p.Jitter)
+		jitterLock.Lock()
+		d = d - spread + time.Duration(jitterRand.Int63n(int64(spread)+1))
+		jitterLock.Unlock()
+	}
+	if p.RespectRetryAfter && resp != nil {
+		if after, ok := retryAfter(resp); ok && after > d {
+			d = after
+			if p.MaxDelay > 0 && d > p.MaxDelay {
+				d = p.MaxDelay
+			}
+		}
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header which is either seconds or a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// rewind prepares the request to be sent again. Requests with a body that
+// cannot be recreated are not retried
+func rewind(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discard drains and closes the body so the connection can be reused
+func discard(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
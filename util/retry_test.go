@@ -0,0 +1,180 @@
+package util
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers the first failures requests with status and the
+// following ones with ok, recording the body of every request
+type flakyServer struct {
+	*httptest.Server
+	failures int
+	status   int
+	bodies   []string
+	lock     *sync.Mutex
+}
+
+func newFlakyServer(failures, status int, header http.Header) *flakyServer {
+	s := &flakyServer{failures: failures, status: status, lock: new(sync.Mutex)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.lock.Lock()
+		s.bodies = append(s.bodies, string(body))
+		failing := len(s.bodies) <= s.failures
+		s.lock.Unlock()
+		if failing {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(s.status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	return s
+}
+
+func (s *flakyServer) requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+// fastRetries retries the default statuses without waiting
+func fastRetries(attempts int) *RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.MaxAttempts = attempts
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = 5 * time.Millisecond
+	p.Jitter = 0
+	return p
+}
+
+func doFlaky(t *testing.T, s *flakyServer, p *RetryPolicy, req *http.Request) *http.Response {
+	t.Helper()
+	n := NewThrottledClient(time.Millisecond, 0, WithRetryPolicy(p))
+	n.Start()
+	defer n.Stop()
+	resp, err := n.Do(req).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		attempts int
+		// requests is the number of requests the server must receive
+		requests   int
+		wantStatus int
+	}{
+		{"succeeds after failures", 2, http.StatusServiceUnavailable, 5, 3, http.StatusOK},
+		{"throttled", 1, http.StatusTooManyRequests, 5, 2, http.StatusOK},
+		{"gives up after MaxAttempts", 10, http.StatusBadGateway, 3, 3, http.StatusBadGateway},
+		{"status not retried", 10, http.StatusNotFound, 5, 1, http.StatusNotFound},
+		{"zero attempts send once", 10, http.StatusInternalServerError, 0, 1, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFlakyServer(tt.failures, tt.status, nil)
+			defer s.Close()
+			resp := doFlaky(t, s, fastRetries(tt.attempts), get(t, s.URL))
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := len(s.requests()); got != tt.requests {
+				t.Fatalf("server got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	s := newFlakyServer(2, http.StatusServiceUnavailable, nil)
+	defer s.Close()
+	req, err := http.NewRequest("POST", s.URL, strings.NewReader("node=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doFlaky(t, s, fastRetries(5), req)
+	bodies := s.requests()
+	if len(bodies) != 3 {
+		t.Fatalf("server got %d requests, want 3", len(bodies))
+	}
+	for i, b := range bodies {
+		if b != "node=1" {
+			t.Fatalf("attempt %d sent body %q", i+1, b)
+		}
+	}
+}
+
+func TestRetryWaitsForRetryAfter(t *testing.T) {
+	s := newFlakyServer(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	defer s.Close()
+	p := fastRetries(3)
+	p.MaxDelay = 2 * time.Second
+	start := time.Now()
+	doFlaky(t, s, p, get(t, s.URL))
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("retried after %s, before the Retry-After of 1s", waited)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	retryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {v}}}
+	}
+	policy := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, RespectRetryAfter: true}
+	tests := []struct {
+		name    string
+		attempt int
+		resp    *http.Response
+		want    time.Duration
+	}{
+		{"first retry waits BaseDelay", 1, nil, 100 * time.Millisecond},
+		{"doubles every attempt", 3, nil, 400 * time.Millisecond},
+		{"capped at MaxDelay", 10, nil, time.Second},
+		{"shorter Retry-After keeps the backoff", 1, retryAfter("0"), 100 * time.Millisecond},
+		{"Retry-After in seconds", 1, retryAfter("1"), time.Second},
+		{"Retry-After capped at MaxDelay", 1, retryAfter("120"), time.Second},
+		{"invalid Retry-After ignored", 2, retryAfter("soon"), 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.delay(tt.attempt, tt.resp); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	date := retryAfter(time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat))
+	uncapped := &RetryPolicy{BaseDelay: 100 * time.Millisecond, RespectRetryAfter: true}
+	if got := uncapped.delay(1, date); got < 8*time.Second || got > 10*time.Second {
+		t.Fatalf("got %s for a Retry-After date 10s away", got)
+	}
+	ignored := &RetryPolicy{BaseDelay: 100 * time.Millisecond}
+	if got := ignored.delay(1, retryAfter("5")); got != 100*time.Millisecond {
+		t.Fatalf("got %s with RespectRetryAfter unset", got)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1, nil); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("jittered delay %s outside [50ms, 100ms]", d)
+		}
+	}
+}